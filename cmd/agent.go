@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"termpilot/models"
+	"termpilot/ollamaclient"
+	"termpilot/tools"
+)
+
+const maxAgentSteps = 8
+
+func toClientMessages(messages []models.Message) []ollamaclient.Message {
+	clientMessages := make([]ollamaclient.Message, 0, len(messages))
+	for _, message := range messages {
+		// Queued prompts wait for their turn instead of joining the history
+		if message.Pending {
+			continue
+		}
+		clientMessage := ollamaclient.Message{
+			Role:       message.Role,
+			Content:    attachmentText(message.Attachments) + message.Content,
+			ToolCallID: message.ToolCallID,
+			Images:     toClientImages(message.Images),
+		}
+		if message.ToolCalls != "" {
+			json.Unmarshal([]byte(message.ToolCalls), &clientMessage.ToolCalls)
+		}
+		clientMessages = append(clientMessages, clientMessage)
+	}
+	return clientMessages
+}
+
+func fromClientMessage(message ollamaclient.Message) models.Message {
+	stored := models.Message{
+		Role:       message.Role,
+		Content:    message.Content,
+		ToolCallID: message.ToolCallID,
+	}
+	if len(message.ToolCalls) > 0 {
+		if toolCalls, err := json.Marshal(message.ToolCalls); err == nil {
+			stored.ToolCalls = string(toolCalls)
+		}
+	}
+	return stored
+}
+
+// describeToolCalls renders the tool calls of a stored message as markdown.
+func describeToolCalls(message models.Message) string {
+	if message.ToolCalls == "" {
+		return ""
+	}
+
+	var toolCalls []ollamaclient.ToolCall
+	if err := json.Unmarshal([]byte(message.ToolCalls), &toolCalls); err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, call := range toolCalls {
+		fmt.Fprintf(&b, "- `%s %s`\n", call.Function.Name, call.Function.Arguments)
+	}
+	return b.String()
+}
+
+// runAgent sends prompt after history and executes the tool calls the model
+// asks for, feeding their results back until it answers without calling a
+// tool. It returns every message added to the exchange, starting with the
+// prompt and ending with the final answer.
+func runAgent(ctx context.Context, ollamaClient *ollamaclient.OllamaClient, registry *tools.Registry, history []ollamaclient.Message, prompt ollamaclient.Message) ([]ollamaclient.Message, error) {
+	messages := append(append([]ollamaclient.Message(nil), history...), prompt)
+	added := len(history)
+
+	for step := 0; step < maxAgentSteps; step++ {
+		reply, err := ollamaClient.ChatCompletionWithTools(ctx, messages, registry.Definitions())
+		if err != nil {
+			return nil, err
+		}
+		reply.Role = "assistant"
+		messages = append(messages, *reply)
+
+		if len(reply.ToolCalls) == 0 {
+			return messages[added:], nil
+		}
+
+		for _, call := range reply.ToolCalls {
+			output, err := registry.Call(ctx, call.Function.Name, call.Function.Arguments)
+			if err != nil {
+				output = fmt.Sprintf("error: %v", err)
+			}
+			messages = append(messages, ollamaclient.Message{
+				Role:       "tool",
+				Content:    output,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+
+	return nil, fmt.Errorf("no answer after %d tool calling steps", maxAgentSteps)
+}
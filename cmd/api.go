@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"termpilot/db"
+	"termpilot/models"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// apiConversation is a conversation as the REST API returns it. Lists leave
+// out the messages.
+type apiConversation struct {
+	ID           string       `json:"id"`
+	Title        string       `json:"title"`
+	Tag          string       `json:"tag,omitempty"`
+	SystemPrompt string       `json:"system_prompt,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	Messages     []apiMessage `json:"messages,omitempty"`
+}
+
+type apiMessage struct {
+	ID         uint            `json:"id"`
+	Role       string          `json:"role"`
+	Content    string          `json:"content"`
+	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	// Files names the images and files attached to the message
+	Files     []string  `json:"files,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiPrompt is the body of requests starting or continuing a conversation.
+type apiPrompt struct {
+	Prompt string `json:"prompt"`
+	// Title, System and Model only apply to new conversations
+	Title  string `json:"title"`
+	System string `json:"system"`
+	Model  string `json:"model"`
+}
+
+func toAPIConversation(conversation models.Conversation) apiConversation {
+	return apiConversation{
+		ID:           conversation.ID,
+		Title:        conversation.Title,
+		Tag:          conversation.Tag,
+		SystemPrompt: conversation.SystemPrompt,
+		Model:        conversation.Model,
+		CreatedAt:    conversation.CreatedAt,
+		UpdatedAt:    conversation.UpdatedAt,
+		Messages:     toAPIMessages(conversation.Messages),
+	}
+}
+
+func toAPIMessages(messages []models.Message) []apiMessage {
+	var converted []apiMessage
+	for _, message := range messages {
+		apiMsg := apiMessage{
+			ID:         message.ID,
+			Role:       message.Role,
+			Content:    message.Content,
+			ToolCallID: message.ToolCallID,
+			CreatedAt:  message.CreatedAt,
+		}
+		if message.ToolCalls != "" {
+			apiMsg.ToolCalls = json.RawMessage(message.ToolCalls)
+		}
+		for _, image := range message.Images {
+			apiMsg.Files = append(apiMsg.Files, image.Filename)
+		}
+		for _, attachment := range message.Attachments {
+			apiMsg.Files = append(apiMsg.Files, attachment.Filename)
+		}
+		converted = append(converted, apiMsg)
+	}
+	return converted
+}
+
+// authorize lets requests through only with the server's bearer token, if
+// it has one.
+func (s *server) authorize(next http.HandlerFunc) http.HandlerFunc {
+	if s.token == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="termpilot"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// conversationOr404 loads the conversation named in the path, writing an
+// error and returning nil if it cannot.
+func conversationOr404(w http.ResponseWriter, r *http.Request) *models.Conversation {
+	conversation, err := db.GetConversation(r.PathValue("id"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no conversation "+r.PathValue("id"))
+		return nil
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	return conversation
+}
+
+func readPrompt(w http.ResponseWriter, r *http.Request) (apiPrompt, bool) {
+	var prompt apiPrompt
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&prompt); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return prompt, false
+	}
+	if strings.TrimSpace(prompt.Prompt) == "" {
+		writeError(w, http.StatusBadRequest, "prompt is required")
+		return prompt, false
+	}
+	return prompt, true
+}
+
+// listConversationsAPI lists the conversations, all of them or those
+// matching the q search or tag prefix parameters.
+func (s *server) listConversationsAPI(w http.ResponseWriter, r *http.Request) {
+	var conversations []models.Conversation
+	var err error
+	switch query := r.URL.Query(); {
+	case query.Get("q") != "":
+		conversations, err = db.SearchConversations(query.Get("q"))
+	case query.Get("tag") != "":
+		conversations, err = db.GetConversationsByTagPrefix(query.Get("tag"))
+	default:
+		conversations, err = db.GetAllConversations()
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	listed := make([]apiConversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		conversation.Messages = nil
+		listed = append(listed, toAPIConversation(conversation))
+	}
+	writeJSON(w, http.StatusOK, listed)
+}
+
+func (s *server) getConversationAPI(w http.ResponseWriter, r *http.Request) {
+	if conversation := conversationOr404(w, r); conversation != nil {
+		writeJSON(w, http.StatusOK, toAPIConversation(*conversation))
+	}
+}
+
+// createConversationAPI starts a conversation with a prompt and returns it
+// with the reply.
+func (s *server) createConversationAPI(w http.ResponseWriter, r *http.Request) {
+	prompt, ok := readPrompt(w, r)
+	if !ok {
+		return
+	}
+
+	conversation := newConversation()
+	conversation.Title = prompt.Title
+	conversation.SystemPrompt = prompt.System
+	conversation.Model = prompt.Model
+	if _, err := newChatSession(conversation, s.client, chatOptions{}).send(r.Context(), prompt.Prompt); err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, toAPIConversation(*conversation))
+}
+
+// continueConversationAPI sends a prompt in a conversation and returns the
+// messages it added.
+func (s *server) continueConversationAPI(w http.ResponseWriter, r *http.Request) {
+	conversation := conversationOr404(w, r)
+	if conversation == nil {
+		return
+	}
+	prompt, ok := readPrompt(w, r)
+	if !ok {
+		return
+	}
+
+	before := len(conversation.Messages)
+	if _, err := newChatSession(conversation, s.client, chatOptions{}).send(r.Context(), prompt.Prompt); err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIMessages(conversation.Messages[before:]))
+}
+
+func (s *server) deleteConversationAPI(w http.ResponseWriter, r *http.Request) {
+	conversation := conversationOr404(w, r)
+	if conversation == nil {
+		return
+	}
+	if err := db.DeleteConversation(conversation.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
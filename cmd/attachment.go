@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"termpilot/db"
+	"termpilot/models"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	convAttachmentsCmd.Flags().String("extract", "", "write the attachments to this directory")
+	convAttachmentsCmd.Flags().Uint("id", 0, "only the attachment with this id")
+
+	convCmd.AddCommand(convAttachmentsCmd)
+	rootCmd.AddCommand(convCmd)
+}
+
+// loadAttachment reads the file at path to attach it to a prompt.
+func loadAttachment(path string) (models.Attachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	if info.IsDir() {
+		return models.Attachment{}, fmt.Errorf("%s is a directory", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(content)
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(content))
+	return models.Attachment{
+		Filename: filepath.Base(path),
+		Path:     path,
+		MimeType: mimeType,
+		Size:     int64(len(content)),
+		BlobHash: hash,
+		Blob:     models.Blob{Hash: hash, Content: content},
+	}, nil
+}
+
+func loadAttachments(paths []string) ([]models.Attachment, error) {
+	attachments := make([]models.Attachment, 0, len(paths))
+	for _, path := range paths {
+		attachment, err := loadAttachment(path)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func quoteFile(name string, content string) string {
+	return fmt.Sprintf("File %s:\n```\n%s\n```\n\n", name, strings.TrimRight(content, "\n"))
+}
+
+// attachmentText quotes the attachments for the model. Binary files are only
+// named since their content means nothing to it.
+func attachmentText(attachments []models.Attachment) string {
+	var b strings.Builder
+	for _, attachment := range attachments {
+		name := attachment.Path
+		if name == "" {
+			name = attachment.Filename
+		}
+		if !utf8.Valid(attachment.Blob.Content) {
+			fmt.Fprintf(&b, "File %s (%s, %s) is attached but not text.\n\n", name, attachment.MimeType, formatSize(attachment.Size))
+			continue
+		}
+		b.WriteString(quoteFile(name, string(attachment.Blob.Content)))
+	}
+	return b.String()
+}
+
+// describeAttachments renders markers for the files attached to a message.
+func describeAttachments(message models.Message) string {
+	var b strings.Builder
+	for _, attachment := range message.Attachments {
+		fmt.Fprintf(&b, "*[file: %s, %s]*\n\n", attachment.Filename, formatSize(attachment.Size))
+	}
+	return b.String()
+}
+
+func listAttachments(out io.Writer, attachments []models.Attachment) {
+	if len(attachments) == 0 {
+		fmt.Fprintln(out, "No attachments.")
+		return
+	}
+	fmt.Fprintf(out, "Attachments (%d):\n", len(attachments))
+	for _, attachment := range attachments {
+		fmt.Fprintf(out, "  %d. %s (%s, %s) %s\n", attachment.ID, attachment.Filename, attachment.MimeType, formatSize(attachment.Size), attachment.BlobHash[:12])
+	}
+}
+
+// extractAttachments writes the attachments to dir, numbering files that
+// would otherwise overwrite each other, and returns the paths written.
+func extractAttachments(dir string, attachments []models.Attachment) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var written []string
+	used := map[string]bool{}
+	for _, attachment := range attachments {
+		name := attachment.Filename
+		if used[name] {
+			name = strconv.FormatUint(uint64(attachment.ID), 10) + "-" + name
+		}
+		used[name] = true
+
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, attachment.Blob.Content, 0644); err != nil {
+			return nil, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+var convCmd = &cobra.Command{
+	Use:   "conv",
+	Short: "Inspect saved conversations",
+}
+
+var convAttachmentsCmd = &cobra.Command{
+	Use:   "attachments <conversation-id>",
+	Short: "List or extract the files attached in a conversation",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		extract, err := cmd.Flags().GetString("extract")
+		if err != nil {
+			log.Fatalf("Failed to get extract: %v", err)
+		}
+
+		id, err := cmd.Flags().GetUint("id")
+		if err != nil {
+			log.Fatalf("Failed to get id: %v", err)
+		}
+
+		if _, err := db.GetConversation(args[0]); err != nil {
+			log.Fatalf("Failed to get conversation: %v", err)
+		}
+
+		attachments, err := db.GetAttachments(args[0])
+		if err != nil {
+			log.Fatalf("Failed to get attachments: %v", err)
+		}
+
+		if id != 0 {
+			var selected []models.Attachment
+			for _, attachment := range attachments {
+				if attachment.ID == id {
+					selected = append(selected, attachment)
+				}
+			}
+			if len(selected) == 0 {
+				log.Fatalf("No attachment %d in conversation %s", id, args[0])
+			}
+			attachments = selected
+		}
+
+		if extract == "" {
+			listAttachments(os.Stdout, attachments)
+			return
+		}
+
+		written, err := extractAttachments(extract, attachments)
+		if err != nil {
+			log.Fatalf("Failed to extract attachments: %v", err)
+		}
+		for _, path := range written {
+			fmt.Println(path)
+		}
+	},
+}
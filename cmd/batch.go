@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+
+	"termpilot/ollamaclient"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultBatchConcurrency = 4
+
+func init() {
+	batchCmd.Flags().StringP("output", "o", "", "append the results to this JSONL file and skip items already in it")
+	batchCmd.Flags().IntP("concurrency", "c", defaultBatchConcurrency, "number of prompts run at the same time")
+
+	rootCmd.AddCommand(batchCmd)
+}
+
+// batchItem is one line of a batch input. Either Prompt or Messages ending
+// with a user message holds the prompt.
+type batchItem struct {
+	ID       string                 `json:"id"`
+	Prompt   string                 `json:"prompt"`
+	System   string                 `json:"system"`
+	Messages []ollamaclient.Message `json:"messages"`
+	Model    string                 `json:"model"`
+	Options  *ollamaclient.Options  `json:"options"`
+
+	// err is set when the line could not be parsed
+	err error
+}
+
+// batchResult is one line of a batch output.
+type batchResult struct {
+	ID         string              `json:"id"`
+	Model      string              `json:"model,omitempty"`
+	Response   string              `json:"response,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	DurationMs int64               `json:"duration_ms,omitempty"`
+	Usage      *ollamaclient.Usage `json:"usage,omitempty"`
+}
+
+type batchSummary struct {
+	succeeded int
+	failed    int
+	skipped   int
+}
+
+// readBatchItems parses the input, one item per non-empty line. Items
+// without an id are named after their line number.
+func readBatchItems(r io.Reader) ([]batchItem, error) {
+	var items []batchItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var item batchItem
+		if err := json.Unmarshal(text, &item); err != nil {
+			item = batchItem{err: fmt.Errorf("invalid item: %v", err)}
+		}
+		if item.ID == "" {
+			item.ID = "line-" + strconv.Itoa(line)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// openBatchOutput opens path for appending and returns the ids already
+// answered in it. Failed items are run again, so their lines are dropped
+// first, along with a line cut off by an interruption; every id then has
+// at most one line.
+func openBatchOutput(path string) (*os.File, map[string]bool, error) {
+	done := map[string]bool{}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	var kept []byte
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var result batchResult
+		if bytes.HasSuffix(line, []byte("\n")) && json.Unmarshal(line, &result) == nil && result.Error == "" {
+			done[result.ID] = true
+			kept = append(kept, line...)
+		}
+	}
+	if len(kept) != len(data) {
+		if err := writeFileAtomic(path, kept); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, done, nil
+}
+
+// runBatchItem answers one item with its own model and options.
+func runBatchItem(ctx context.Context, ollamaClient *ollamaclient.OllamaClient, item batchItem) batchResult {
+	client := *ollamaClient
+	if item.Model != "" {
+		client.Model = item.Model
+	}
+	if item.Options != nil {
+		client.Options = *item.Options
+	}
+
+	result := batchResult{ID: item.ID, Model: client.Model}
+	if item.err != nil {
+		result.Error = item.err.Error()
+		return result
+	}
+
+	messages, prompt := item.Messages, item.Prompt
+	if prompt == "" {
+		if len(messages) == 0 || messages[len(messages)-1].Role != "user" {
+			result.Error = "item needs a prompt or messages ending with a user message"
+			return result
+		}
+		prompt = messages[len(messages)-1].Content
+		messages = messages[:len(messages)-1]
+	}
+	if item.System != "" {
+		messages = append([]ollamaclient.Message{{Role: "system", Content: item.System}}, messages...)
+	}
+
+	completion, err := client.ChatCompletionStats(ctx, prompt, messages)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Response = completion.Content
+	result.DurationMs = completion.Duration.Milliseconds()
+	result.Usage = &completion.Usage
+	return result
+}
+
+// runBatch answers the items not in done with up to concurrency requests at
+// a time, writing each result as a line to out once it is ready. Items cut
+// off by cancelling ctx are not written, so a later run picks them up.
+func runBatch(ctx context.Context, ollamaClient *ollamaclient.OllamaClient, items []batchItem, done map[string]bool, out io.Writer, concurrency int) (batchSummary, error) {
+	var summary batchSummary
+	var mu sync.Mutex
+	var writeErr error
+
+	queue := make(chan batchItem)
+	var wg sync.WaitGroup
+	for i := 0; i < max(concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				result := runBatchItem(ctx, ollamaClient, item)
+				if ctx.Err() != nil {
+					continue
+				}
+
+				line, err := json.Marshal(result)
+				mu.Lock()
+				if err == nil {
+					_, err = out.Write(append(line, '\n'))
+				}
+				if err != nil && writeErr == nil {
+					writeErr = err
+				}
+				if result.Error == "" {
+					summary.succeeded++
+				} else {
+					summary.failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, item := range items {
+		if done[item.ID] {
+			summary.skipped++
+			continue
+		}
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if writeErr != nil {
+		return summary, writeErr
+	}
+	return summary, ctx.Err()
+}
+
+var batchCmd = &cobra.Command{
+	Use:   "batch <input.jsonl>",
+	Short: "Run the prompts of a JSONL file and write the answers as JSONL",
+	Long: `Run the prompts of a JSONL file, one item per line:
+
+  {"id": "greeting", "prompt": "Say hi", "system": "Be brief", "model": "llama3.2",
+   "options": {"temperature": 0.2, "top_p": 0.9, "num_ctx": 4096, "seed": 1}}
+  {"messages": [{"role": "user", "content": "Say hi"}]}
+
+Only a prompt or messages ending with a user message is required. Each result
+is written as a line with the id, model, response or error, duration and
+token usage. With -o, results are appended to the file and items it already
+answered are skipped, so an interrupted run resumes where it stopped. Failed
+items are run again and their error lines replaced, so each id keeps one line.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		outputPath, err := cmd.Flags().GetString("output")
+		if err != nil {
+			log.Fatalf("Failed to get output: %v", err)
+		}
+
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			log.Fatalf("Failed to get concurrency: %v", err)
+		}
+
+		input := os.Stdin
+		if args[0] != "-" {
+			if input, err = os.Open(args[0]); err != nil {
+				log.Fatalf("Failed to open input: %v", err)
+			}
+			defer input.Close()
+		}
+
+		items, err := readBatchItems(input)
+		if err != nil {
+			log.Fatalf("Failed to read input: %v", err)
+		}
+
+		var out io.Writer = os.Stdout
+		done := map[string]bool{}
+		if outputPath != "" {
+			file, finished, err := openBatchOutput(outputPath)
+			if err != nil {
+				log.Fatalf("Failed to open output: %v", err)
+			}
+			defer file.Close()
+			out, done = file, finished
+		}
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		summary, err := runBatch(ctx, getOllamaClient(), items, done, out, concurrency)
+		fmt.Fprintf(os.Stderr, "%d succeeded, %d failed, %d skipped as already answered\n", summary.succeeded, summary.failed, summary.skipped)
+		if err == context.Canceled {
+			fmt.Fprintln(os.Stderr, "Interrupted, run the same command again to resume.")
+			return
+		}
+		if err != nil {
+			log.Fatalf("Failed to write results: %v", err)
+		}
+	},
+}
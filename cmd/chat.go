@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 	"unicode"
@@ -11,9 +13,12 @@ import (
 	"termpilot/db"
 	"termpilot/models"
 	"termpilot/ollamaclient"
+	"termpilot/rag"
+	"termpilot/tools"
 
 	"github.com/charmbracelet/glamour"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -22,6 +27,18 @@ func init() {
 	chatCmd.Flags().Bool("continue-last", false, "continue the last conversation")
 	chatCmd.Flags().Bool("list-models", false, "list all models")
 	chatCmd.Flags().String("show", "", "show a conversation")
+	chatCmd.Flags().String("rag", "", "ground answers in the chunks of an index")
+	chatCmd.Flags().Int("top-k", rag.DefaultTopK, "number of chunks retrieved with --rag")
+	chatCmd.Flags().BoolP("interactive", "i", false, "start an interactive session bound to one conversation")
+	chatCmd.Flags().Bool("tools", false, "let the model call the built-in tools (read_file, list_dir, grep, current_time)")
+	chatCmd.Flags().String("template", "", "build the prompt from a template")
+	chatCmd.Flags().StringArray("var", nil, "template variable as name=value (repeatable)")
+	chatCmd.Flags().StringArrayP("file", "f", nil, "attach a file to the prompt (repeatable)")
+	chatCmd.Flags().StringArray("image", nil, "attach an image for vision models (repeatable)")
+	chatCmd.Flags().String("json-schema", "", "reply with JSON validated against the schema in this file")
+	chatCmd.Flags().Int("json-retries", defaultJSONRetries, "times to ask again when the reply does not match --json-schema")
+	chatCmd.Flags().Bool("queue", false, "queue the prompt when ollama is unreachable, to send it later with termpilot queue run (default is the queue config value)")
+	viper.BindPFlag("queue", chatCmd.Flags().Lookup("queue"))
 }
 
 func fancyPrint(text string) string {
@@ -38,6 +55,10 @@ func fancyPrint(text string) string {
 	return out
 }
 
+func newConversationID() string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(time.Now().String())))[:8]
+}
+
 func listConversations() {
 	conversations, err := db.GetAllConversations()
 	if err != nil {
@@ -60,11 +81,128 @@ func showConversation(conversationId string) {
 		role := []rune(message.Role)
 		role[0] = unicode.ToUpper(role[0])
 		fmt.Print(fancyPrint("## " + string(role) + ":"))
+		if files := describeImages(message) + describeAttachments(message); files != "" {
+			fmt.Print(fancyPrint(files))
+		}
+		if calls := describeToolCalls(message); calls != "" {
+			fmt.Print(fancyPrint(calls))
+		}
 		fmt.Print(fancyPrint(message.Content))
 	}
 }
 
-func continueConversation(conversationId string, args []string, ollamaClient *ollamaclient.OllamaClient) {
+type chatOptions struct {
+	system string
+	rag    ragOptions
+	tools  *tools.Registry
+	// attachments and images go with the prompt
+	attachments []models.Attachment
+	images      []models.Image
+	// queue keeps prompts that cannot reach the model as pending messages
+	queue bool
+}
+
+// complete sends prompt after history and returns the messages to append to
+// the conversation. The last one is the assistant's answer.
+func complete(ctx context.Context, prompt string, history []models.Message, opts chatOptions, ollamaClient *ollamaclient.OllamaClient) ([]models.Message, error) {
+	augmented, err := opts.rag.augment(prompt, ollamaClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve context: %v", err)
+	}
+
+	if opts.system != "" {
+		history = append([]models.Message{{Role: "system", Content: opts.system}}, history...)
+	}
+
+	request := ollamaclient.Message{
+		Role:    "user",
+		Content: attachmentText(opts.attachments) + augmented,
+		Images:  toClientImages(opts.images),
+	}
+
+	if opts.tools == nil {
+		reply, err := ollamaClient.ChatCompletionWithTools(ctx, append(toClientMessages(history), request), nil)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Message{
+			{Content: prompt, Role: "user", Images: opts.images, Attachments: opts.attachments},
+			{Content: reply.Content, Role: "assistant"},
+		}, nil
+	}
+
+	exchange, err := runAgent(ctx, ollamaClient, opts.tools, toClientMessages(history), request)
+	if err != nil {
+		return nil, err
+	}
+
+	added := make([]models.Message, 0, len(exchange))
+	for _, message := range exchange {
+		added = append(added, fromClientMessage(message))
+	}
+	// Store the prompt as typed rather than with the retrieved context
+	added[0].Content = prompt
+	added[0].Images = opts.images
+	added[0].Attachments = opts.attachments
+	return added, nil
+}
+
+// firstRunes returns the first n characters of text, for titles, without
+// cutting a character in two.
+func firstRunes(text string, n int) string {
+	for i := range text {
+		if n == 0 {
+			return text[:i]
+		}
+		n--
+	}
+	return text
+}
+
+// sendPrompt completes prompt in the context of conversation, then appends
+// the exchange to it and saves it. It returns the assistant's answer.
+func sendPrompt(ctx context.Context, conversation *models.Conversation, prompt string, opts chatOptions, ollamaClient *ollamaclient.OllamaClient) (string, error) {
+	if opts.system == "" {
+		opts.system = conversation.SystemPrompt
+	}
+
+	added, err := complete(ctx, prompt, conversation.Messages, opts, ollamaClient)
+	if err != nil && opts.queue && isUnreachable(err) {
+		return "", queuePrompt(conversation, prompt, opts)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if conversation.Title == "" {
+		conversation.Title = firstRunes(prompt, 20)
+	}
+	conversation.Messages = append(conversation.Messages, added...)
+
+	if err := saveConversation(conversation); err != nil {
+		return "", err
+	}
+
+	return added[len(added)-1].Content, nil
+}
+
+// saveConversation creates conversation if it has never been stored and
+// updates it otherwise.
+func saveConversation(conversation *models.Conversation) error {
+	save := db.UpdateConversation
+	if conversation.CreatedAt.IsZero() {
+		save = db.CreateConversation
+	}
+
+	saved, err := save(*conversation)
+	if err != nil {
+		return err
+	}
+	*conversation = *saved
+	return nil
+}
+
+func continueConversation(conversationId string, args []string, opts chatOptions, ollamaClient *ollamaclient.OllamaClient) {
 	conversation, err := db.GetConversation(conversationId)
 	if err != nil {
 		log.Fatalf("Failed to get conversation: %v", err)
@@ -74,43 +212,24 @@ func continueConversation(conversationId string, args []string, ollamaClient *ol
 		log.Fatalf("Conversation has no messages")
 	}
 
-	var messages []ollamaclient.Message
-
-	for _, message := range conversation.Messages {
-		messages = append(messages, ollamaclient.Message{
-			Role:    message.Role,
-			Content: message.Content,
-		})
-	}
-
 	prompt := strings.Join(args, " ")
 
-	response, err := ollamaClient.ChatCompletion(prompt, messages)
+	response, err := sendPrompt(context.Background(), conversation, prompt, opts, ollamaClient)
 	if err != nil {
 		log.Fatalf("Failed to get response: %v", err)
 	}
 
-	conversation.Messages = append(conversation.Messages, models.Message{Content: prompt, Role: "user"})
-	conversation.Messages = append(conversation.Messages, models.Message{Content: response, Role: "assistant"})
-
-	db.UpdateConversation(*conversation)
-
 	fmt.Print(fancyPrint(response))
 }
 
-func startConversation(args []string, ollamaClient *ollamaclient.OllamaClient) {
+func startConversation(args []string, opts chatOptions, ollamaClient *ollamaclient.OllamaClient) {
 	prompt := strings.Join(args, " ")
-	response, err := ollamaClient.ChatCompletion(prompt, []ollamaclient.Message{})
+
+	response, err := sendPrompt(context.Background(), newConversation(), prompt, opts, ollamaClient)
 	if err != nil {
 		log.Fatalf("Failed to get response: %v", err)
 	}
 
-	db.CreateConversation(models.Conversation{
-		ID:       fmt.Sprintf("%x", sha256.Sum256([]byte(time.Now().String())))[:8],
-		Title:    prompt[:min(len(prompt), 20)],
-		Messages: []models.Message{{Content: prompt, Role: "user"}, {Content: response, Role: "assistant"}},
-	})
-
 	fmt.Print(fancyPrint(response))
 }
 
@@ -125,41 +244,35 @@ var chatCmd = &cobra.Command{
 	Use:   "chat",
 	Short: "Chat with Termpilot",
 	Run: func(cmd *cobra.Command, args []string) {
-		baseUrl, err := cmd.Flags().GetString("base-url")
-		if err != nil {
-			log.Fatalf("Failed to get base-url: %v", err)
-		}
-
-		port, err := cmd.Flags().GetString("port")
+		list, err := cmd.Flags().GetBool("list")
 		if err != nil {
-			log.Fatalf("Failed to get port: %v", err)
+			log.Fatalf("Failed to get list: %v", err)
 		}
 
-		model, err := cmd.Flags().GetString("model")
-		if err != nil {
-			log.Fatalf("Failed to get model: %v", err)
+		if list {
+			listConversations()
+			return
 		}
 
-		version, err := cmd.Flags().GetString("version")
+		showConversationId, err := cmd.Flags().GetString("show")
 		if err != nil {
-			log.Fatalf("Failed to get version: %v", err)
+			log.Fatalf("Failed to get show: %v", err)
 		}
 
-		if err := ollamaclient.StartOllamaIfNotRunning(); err != nil {
-			log.Fatalf("Failed to start ollama: %v", err)
+		if showConversationId != "" {
+			showConversation(showConversationId)
+			return
 		}
 
-		list, err := cmd.Flags().GetBool("list")
-		if err != nil {
-			log.Fatalf("Failed to get list: %v", err)
-		}
+		cfg := currentConfig()
+		queue := cfg.Queue
 
-		if list {
-			listConversations()
-			return
+		// Everything below talks to the model, unless the prompt is queued
+		if err := startOllama(); err != nil && !queue {
+			log.Fatalf("Failed to start ollama: %v", err)
 		}
 
-		ollamaClient := ollamaclient.NewOllamaClient(baseUrl, model, port, version)
+		ollamaClient := cfg.ollamaClient()
 
 		listModels, err := cmd.Flags().GetBool("list-models")
 		if err != nil {
@@ -175,14 +288,69 @@ var chatCmd = &cobra.Command{
 			return
 		}
 
-		conversationId, err := cmd.Flags().GetString("continue")
+		ragIndex, err := cmd.Flags().GetString("rag")
 		if err != nil {
-			log.Fatalf("Failed to get continue: %v", err)
+			log.Fatalf("Failed to get rag: %v", err)
 		}
 
-		if conversationId != "" {
-			continueConversation(conversationId, args, ollamaClient)
-			return
+		topK, err := cmd.Flags().GetInt("top-k")
+		if err != nil {
+			log.Fatalf("Failed to get top-k: %v", err)
+		}
+
+		opts := chatOptions{rag: ragOptions{index: ragIndex, topK: topK}, queue: queue}
+
+		useTools, err := cmd.Flags().GetBool("tools")
+		if err != nil {
+			log.Fatalf("Failed to get tools: %v", err)
+		}
+
+		if useTools {
+			opts.tools, err = tools.NewBuiltinRegistry(".")
+			if err != nil {
+				log.Fatalf("Failed to set up tools: %v", err)
+			}
+		}
+
+		templateName, err := cmd.Flags().GetString("template")
+		if err != nil {
+			log.Fatalf("Failed to get template: %v", err)
+		}
+
+		vars, err := cmd.Flags().GetStringArray("var")
+		if err != nil {
+			log.Fatalf("Failed to get var: %v", err)
+		}
+
+		files, err := cmd.Flags().GetStringArray("file")
+		if err != nil {
+			log.Fatalf("Failed to get file: %v", err)
+		}
+
+		if templateName != "" {
+			prompt, attach, err := buildPrompt(templateName, vars, files, args)
+			if err != nil {
+				log.Fatalf("Failed to build prompt: %v", err)
+			}
+			args, files = []string{prompt}, attach
+		}
+
+		if opts.attachments, err = loadAttachments(files); err != nil {
+			log.Fatalf("Failed to attach file: %v", err)
+		}
+
+		imagePaths, err := cmd.Flags().GetStringArray("image")
+		if err != nil {
+			log.Fatalf("Failed to get image: %v", err)
+		}
+
+		if opts.images, err = loadImages(imagePaths); err != nil {
+			log.Fatalf("Failed to load image: %v", err)
+		}
+
+		conversationId, err := cmd.Flags().GetString("continue")
+		if err != nil {
+			log.Fatalf("Failed to get continue: %v", err)
 		}
 
 		continueLast, err := cmd.Flags().GetBool("continue-last")
@@ -190,27 +358,81 @@ var chatCmd = &cobra.Command{
 			log.Fatalf("Failed to get continue-last: %v", err)
 		}
 
-		if continueLast {
-			conversation, err := db.GetLastConversation()
+		interactive, err := cmd.Flags().GetBool("interactive")
+		if err != nil {
+			log.Fatalf("Failed to get interactive: %v", err)
+		}
+
+		schemaPath, err := cmd.Flags().GetString("json-schema")
+		if err != nil {
+			log.Fatalf("Failed to get json-schema: %v", err)
+		}
+
+		if schemaPath != "" {
+			if interactive {
+				log.Fatalf("--json-schema does not work with --interactive")
+			}
 
+			jsonRetries, err := cmd.Flags().GetInt("json-retries")
 			if err != nil {
-				log.Fatalf("Failed to get last conversation: %v", err)
+				log.Fatalf("Failed to get json-retries: %v", err)
 			}
 
-			continueConversation(conversation.ID, args, ollamaClient)
+			conversation := newConversation()
+			if conversationId != "" {
+				if conversation, err = db.GetConversation(conversationId); err != nil {
+					log.Fatalf("Failed to get conversation: %v", err)
+				}
+			} else if continueLast {
+				if conversation, err = db.GetLastConversation(); err != nil {
+					log.Fatalf("Failed to get last conversation: %v", err)
+				}
+			}
+
+			answerJSON(conversation, args, opts, ollamaClient, schemaPath, jsonRetries)
 			return
 		}
 
-		showConversationId, err := cmd.Flags().GetString("show")
-		if err != nil {
-			log.Fatalf("Failed to get show: %v", err)
+		if interactive {
+			conversation := newConversation()
+			if conversationId != "" {
+				if conversation, err = db.GetConversation(conversationId); err != nil {
+					log.Fatalf("Failed to get conversation: %v", err)
+				}
+			} else if continueLast {
+				if conversation, err = db.GetLastConversation(); err != nil {
+					log.Fatalf("Failed to get last conversation: %v", err)
+				}
+			}
+
+			session := newREPL(conversation, ollamaClient, opts, os.Stdout)
+			if len(args) > 0 {
+				if err := session.send(strings.Join(args, " ")); err != nil {
+					log.Fatalf("Failed to get response: %v", err)
+				}
+			}
+			if err := session.run(); err != nil {
+				log.Fatalf("Interactive session failed: %v", err)
+			}
+			return
 		}
 
-		if showConversationId != "" {
-			showConversation(showConversationId)
+		if conversationId != "" {
+			continueConversation(conversationId, args, opts, ollamaClient)
+			return
+		}
+
+		if continueLast {
+			conversation, err := db.GetLastConversation()
+
+			if err != nil {
+				log.Fatalf("Failed to get last conversation: %v", err)
+			}
+
+			continueConversation(conversation.ID, args, opts, ollamaClient)
 			return
 		}
 
-		startConversation(args, ollamaClient)
+		startConversation(args, opts, ollamaClient)
 	},
 }
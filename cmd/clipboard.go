@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+type codeBlock struct {
+	Lang string
+	Code string
+}
+
+// extractCodeBlocks returns the fenced code blocks of a markdown text in order.
+// A block left open runs to the end of the text, as when rendered.
+func extractCodeBlocks(content string) []codeBlock {
+	var blocks []codeBlock
+	var current *codeBlock
+	var fence string
+	var lines []string
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if current == nil {
+			if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+				fence = trimmed[:3]
+				current = &codeBlock{Lang: strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1]))}
+				lines = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			current.Code = strings.Join(lines, "\n")
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if current != nil {
+		current.Code = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+		blocks = append(blocks, *current)
+	}
+	return blocks
+}
+
+// numberCodeBlocks labels each fenced code block of content with its number
+// as used by extractCodeBlocks.
+func numberCodeBlocks(content string) string {
+	var b strings.Builder
+	var fence string
+	n := 0
+	for i, line := range strings.Split(content, "\n") {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		trimmed := strings.TrimSpace(line)
+		if fence == "" && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")) {
+			fence = trimmed[:3]
+			n++
+			fmt.Fprintf(&b, "**[%d]**\n\n", n)
+		} else if fence != "" && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			fence = ""
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// clipboardCommands are tried in order to reach the system clipboard.
+var clipboardCommands = [][]string{
+	{"pbcopy"},
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"clip.exe"},
+}
+
+// copyToClipboard puts text on the system clipboard and returns how it got
+// there. Over SSH, or without a clipboard tool, it falls back to an OSC52
+// escape sequence, which asks the terminal to set its clipboard.
+func copyToClipboard(text string) (string, error) {
+	if os.Getenv("SSH_TTY") == "" && os.Getenv("SSH_CONNECTION") == "" {
+		for _, args := range clipboardCommands {
+			if args[0] == "pbcopy" && runtime.GOOS != "darwin" {
+				continue
+			}
+			if args[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+				continue
+			}
+			if _, err := exec.LookPath(args[0]); err != nil {
+				continue
+			}
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdin = strings.NewReader(text)
+			if err := cmd.Run(); err == nil {
+				return args[0], nil
+			}
+		}
+	}
+
+	if _, err := io.WriteString(os.Stdout, osc52(text, os.Getenv("TMUX") != "")); err != nil {
+		return "", err
+	}
+	return "OSC52", nil
+}
+
+// osc52 builds the sequence setting the clipboard to text, wrapped for tmux
+// when needed.
+func osc52(text string, tmux bool) string {
+	sequence := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if tmux {
+		return "\x1bPtmux;" + strings.ReplaceAll(sequence, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	return sequence
+}
@@ -1,16 +1,37 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	imagepkg "image"
+	"image/color"
+	pngpkg "image/png"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"termpilot/db"
 	"termpilot/models"
+	"termpilot/ollamaclient"
+	"termpilot/shell"
+	"termpilot/templates"
+	"termpilot/testutils"
+	"termpilot/tools"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -143,7 +164,1375 @@ func TestHelpFlag(t *testing.T) {
 	assert.Contains(t, output.String(), "Termpilot is a terminal based AI agent")
 }
 
+func TestEmbedOutput(t *testing.T) {
+	// Inputs come from args, or from non-empty stdin lines
+	inputs, err := readEmbedInputs(nil, strings.NewReader("first\n\n second \n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, inputs)
+
+	inputs, err = readEmbedInputs([]string{"arg"}, strings.NewReader("ignored"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"arg"}, inputs)
+
+	embeddings := [][]float32{{1, 2}, {3, 4}}
+
+	var jsonOut bytes.Buffer
+	assert.NoError(t, writeEmbeddings(&jsonOut, "json", "test-embed", embeddings))
+	assert.JSONEq(t, `{"model":"test-embed","embeddings":[[1,2],[3,4]]}`, jsonOut.String())
+
+	var binaryOut bytes.Buffer
+	assert.NoError(t, writeEmbeddings(&binaryOut, "binary", "test-embed", embeddings))
+	assert.Equal(t, 8+4*4, binaryOut.Len())
+	assert.Equal(t, []byte{2, 0, 0, 0, 2, 0, 0, 0}, binaryOut.Bytes()[:8])
+
+	assert.Error(t, writeEmbeddings(io.Discard, "xml", "test-embed", embeddings))
+}
+
+func TestIndexAndRetrieve(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+	client := testutils.NewTestOllamaClient(server)
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "notes.md"), []byte("termpilot stores conversations in sqlite\n"), 0644))
+
+	index, err := buildIndex(context.Background(), "test-index", root, 10, 0, client)
+	require.NoError(t, err)
+	defer db.DeleteIndex("test-index")
+	assert.Equal(t, 1, len(index.Chunks))
+
+	prompt, err := ragOptions{index: "test-index", topK: 3}.augment("where is history stored?", client)
+	assert.NoError(t, err)
+	assert.Contains(t, prompt, "[1] notes.md:1-1")
+	assert.Contains(t, prompt, "Question: where is history stored?")
+
+	// Without an index the prompt is left untouched
+	prompt, err = ragOptions{}.augment("plain", client)
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", prompt)
+}
+
+func TestAgentToolCalls(t *testing.T) {
+	// The mock model calls current_time first, then answers with the tool output
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+
+		messages := request["messages"].([]interface{})
+		last := messages[len(messages)-1].(map[string]interface{})
+
+		w.Header().Set("Content-Type", "application/json")
+		if last["role"] == "tool" {
+			w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "It is ` + last["content"].(string) + `"}}]}`))
+			return
+		}
+		w.Write([]byte(`{"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": "",
+			"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "current_time", "arguments": "{}"}}]}}]}`))
+	}))
+	defer server.Close()
+
+	client := testutils.NewTestOllamaClient(server)
+	registry, err := tools.NewBuiltinRegistry(t.TempDir())
+	require.NoError(t, err)
+
+	history := []models.Message{{Content: "Hi", Role: "user"}, {Content: "Hello", Role: "assistant"}}
+	added, err := complete(context.Background(), "What time is it?", history, chatOptions{tools: registry}, client)
+	require.NoError(t, err)
+
+	// user prompt, assistant tool call, tool result, final answer
+	require.Equal(t, 4, len(added))
+	assert.Equal(t, "What time is it?", added[0].Content)
+	assert.Contains(t, added[1].ToolCalls, "current_time")
+	assert.Equal(t, "tool", added[2].Role)
+	assert.Equal(t, "call_1", added[2].ToolCallID)
+	assert.Equal(t, "It is "+added[2].Content, added[3].Content)
+	assert.Contains(t, describeToolCalls(added[1]), "current_time {}")
+
+	// Tools are offered to the model and the history is sent along
+	assert.Equal(t, 2, len(requests))
+	assert.NotNil(t, requests[0]["tools"])
+	assert.Equal(t, 3, len(requests[0]["messages"].([]interface{})))
+
+	// Stored tool calls survive the round trip back to client messages
+	clientMessages := toClientMessages(added)
+	assert.Equal(t, "current_time", clientMessages[1].ToolCalls[0].Function.Name)
+	assert.Equal(t, "call_1", clientMessages[2].ToolCallID)
+}
+
+func TestDoHelpers(t *testing.T) {
+	suggestion, err := parseSuggestion("```json\n{\"command\": \"ls -la\", \"explanation\": \"Lists files\"}\n```")
+	assert.NoError(t, err)
+	assert.Equal(t, "ls -la", suggestion.Command)
+	assert.Equal(t, "Lists files", suggestion.Explanation)
+
+	_, err = parseSuggestion(`{"command": "", "explanation": "nothing"}`)
+	assert.Error(t, err)
+
+	_, err = parseSuggestion("just run ls")
+	assert.Error(t, err)
+
+	// High risk commands need the full word
+	assert.False(t, confirmCommand(bufio.NewReader(strings.NewReader("y\n")), io.Discard, shell.RiskHigh))
+	assert.True(t, confirmCommand(bufio.NewReader(strings.NewReader("yes\n")), io.Discard, shell.RiskHigh))
+	assert.True(t, confirmCommand(bufio.NewReader(strings.NewReader("y\n")), io.Discard, shell.RiskLow))
+	assert.False(t, confirmCommand(bufio.NewReader(strings.NewReader("")), io.Discard, shell.RiskLow))
+
+	feedback := formatCommandResult(&shell.Result{Command: "false", Stderr: "boom", ExitCode: 1})
+	assert.Contains(t, feedback, "Exit code: 1")
+	assert.Contains(t, feedback, "boom")
+
+	// The kept tail starts on a character boundary
+	feedback = formatCommandResult(&shell.Result{Command: "cat", Stdout: "x" + strings.Repeat("é", maxFeedbackBytes) + "x"})
+	assert.Contains(t, feedback, "[truncated]")
+	assert.True(t, utf8.ValidString(feedback))
+}
+
+func TestExplainHelpers(t *testing.T) {
+	t.Setenv("TERMPILOT_LAST_COMMAND", "")
+	_, err := lastCommandFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("TERMPILOT_LAST_COMMAND", "termpilot explain")
+	_, err = lastCommandFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("TERMPILOT_LAST_COMMAND", "  go build ./...  ")
+	t.Setenv("TERMPILOT_LAST_STATUS", "2")
+	failed, err := lastCommandFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "go build ./...", failed.Command)
+	assert.Equal(t, 2, failed.ExitCode)
+
+	failed.Stderr = "undefined: foo"
+	prompt := explainPrompt(failed)
+	assert.Contains(t, prompt, "failed with exit status 2")
+	assert.Contains(t, prompt, "go build ./...")
+	assert.Contains(t, prompt, "undefined: foo")
+	assert.NotContains(t, prompt, "stdout:")
+
+	for _, shellName := range []string{"bash", "zsh", "fish"} {
+		assert.Contains(t, shellInits[shellName], "TERMPILOT_LAST_COMMAND")
+		assert.Contains(t, shellInits[shellName], "TERMPILOT_LAST_STATUS")
+	}
+}
+
+func TestREPLFirstPromptFiles(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(path, []byte("meeting at noon"), 0o644))
+	attachments, err := loadAttachments([]string{path})
+	require.NoError(t, err)
+
+	// As chat -i -f notes.txt does
+	var out bytes.Buffer
+	r := newREPL(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{attachments: attachments}, &out)
+	defer db.DeleteConversation(r.session.conversation.ID)
+
+	require.NoError(t, r.send("Summarise the notes"))
+	require.NoError(t, r.send("Thanks"))
+
+	stored, err := db.GetConversation(r.session.conversation.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Messages, 4)
+	require.Len(t, stored.Messages[0].Attachments, 1)
+	assert.Equal(t, "notes.txt", stored.Messages[0].Attachments[0].Filename)
+	assert.Empty(t, stored.Messages[2].Attachments)
+}
+
+func TestREPLCommands(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+
+	var out bytes.Buffer
+	r := newREPL(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{}, &out)
+	session := r.session
+	defer db.DeleteConversation(session.conversation.ID)
+
+	quit, err := r.handleCommand("/model other-model")
+	assert.NoError(t, err)
+	assert.False(t, quit)
+	assert.Equal(t, "other-model", session.client.Model)
+	assert.Contains(t, out.String(), "Model: other-model")
+
+	_, err = r.handleCommand("/system Answer in one word")
+	assert.NoError(t, err)
+	assert.Equal(t, "Answer in one word", session.conversation.SystemPrompt)
+
+	// The first prompt persists the conversation
+	require.NoError(t, r.send("Hello there"))
+	stored, err := db.GetConversation(session.conversation.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello there", stored.Title)
+	assert.Equal(t, "Answer in one word", stored.SystemPrompt)
+	assert.Equal(t, 2, len(stored.Messages))
+
+	// Retrying replaces the last exchange instead of appending to it
+	_, err = r.handleCommand("/retry")
+	assert.NoError(t, err)
+	stored, err = db.GetConversation(session.conversation.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(stored.Messages))
+	assert.Equal(t, "Hello there", stored.Messages[0].Content)
+
+	_, err = r.handleCommand("/save Greetings")
+	assert.NoError(t, err)
+	stored, err = db.GetConversation(session.conversation.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Greetings", stored.Title)
+
+	exportPath := filepath.Join(t.TempDir(), "export.md")
+	_, err = r.handleCommand("/export " + exportPath)
+	assert.NoError(t, err)
+	exported, err := os.ReadFile(exportPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), "## System:\n\nAnswer in one word")
+	assert.Contains(t, string(exported), "## Assistant:\n\nI'm a test response")
+
+	previousID := session.conversation.ID
+	_, err = r.handleCommand("/clear")
+	assert.NoError(t, err)
+	assert.NotEqual(t, previousID, session.conversation.ID)
+	assert.Empty(t, session.conversation.Messages)
+	assert.Equal(t, "Answer in one word", session.conversation.SystemPrompt)
+
+	_, err = r.handleCommand("/bogus")
+	assert.Error(t, err)
+
+	quit, err = r.handleCommand("/exit")
+	assert.NoError(t, err)
+	assert.True(t, quit)
+
+	// /delete removes the stored conversation
+	session.conversation, err = db.GetConversation(previousID)
+	require.NoError(t, err)
+	_, err = runSlashCommand(session, "/delete")
+	assert.NoError(t, err)
+	_, err = db.GetConversation(previousID)
+	assert.Error(t, err)
+}
+
+func TestSlashCommands(t *testing.T) {
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+
+	session := newChatSession(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{})
+
+	output, err := runSlashCommand(session, "/temp 0.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Temperature: 0.2", output)
+	assert.Equal(t, 0.2, *session.client.Options.Temperature)
+
+	_, err = runSlashCommand(session, "/temp hot")
+	assert.Error(t, err)
+
+	_, err = runSlashCommand(session, "/title  Renamed ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", session.conversation.Title)
+
+	path := filepath.Join(t.TempDir(), "context.txt")
+	require.NoError(t, os.WriteFile(path, []byte("file contents\n"), 0644))
+	_, err = runSlashCommand(session, "/file "+path)
+	assert.NoError(t, err)
+	require.Len(t, session.attachments, 1)
+	assert.Equal(t, "context.txt", session.attachments[0].Filename)
+	assert.Equal(t, "file contents\n", string(session.attachments[0].Blob.Content))
+
+	_, err = runSlashCommand(session, "/file /does/not/exist")
+	assert.Error(t, err)
+
+	help, err := runSlashCommand(session, "/help")
+	assert.NoError(t, err)
+	assert.Contains(t, help, "/model [name]")
+
+	// Completion of command names and of model names from ListModels
+	assert.Equal(t, []string{"/temp ", "/title "}, completeSlashCommand(session, "/t"))
+	assert.Equal(t, []string{"/model test-model"}, completeSlashCommand(session, "/model te"))
+	assert.Empty(t, completeSlashCommand(session, "hello"))
+	assert.Equal(t, "/t", commonPrefix([]string{"/temp ", "/title "}))
+}
+
 // Setup helper function
 func initTestDB() error {
 	return db.InitDB()
 }
+
+func TestEditorCommand(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "code --wait")
+	cmd := editorCommand("/tmp/prompt.md")
+	assert.Equal(t, []string{"code", "--wait", "/tmp/prompt.md"}, cmd.Args)
+
+	t.Setenv("VISUAL", "nano")
+	assert.Equal(t, []string{"nano", "/tmp/prompt.md"}, editorCommand("/tmp/prompt.md").Args)
+
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "")
+	assert.Equal(t, []string{"vi", "/tmp/prompt.md"}, editorCommand("/tmp/prompt.md").Args)
+}
+
+func TestReadEdited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.md")
+	require.NoError(t, os.WriteFile(path, []byte("hello\n"), 0600))
+	written := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(path, written, written))
+
+	// Quitting without writing submits nothing
+	msg := readEdited(path, written)
+	require.NoError(t, msg.err)
+	assert.False(t, msg.saved)
+
+	// Saving the text unchanged still submits it
+	require.NoError(t, os.WriteFile(path, []byte("hello\n"), 0600))
+	msg = readEdited(path, written)
+	require.NoError(t, msg.err)
+	assert.True(t, msg.saved)
+	assert.Equal(t, "hello", msg.content)
+
+	os.Remove(path)
+	assert.Error(t, readEdited(path, written).err)
+}
+
+func TestMessageRenderer(t *testing.T) {
+	renderer := newMessageRenderer("dark", darkTheme.styles().roles)
+	messages := []models.Message{
+		{Role: "user", Content: "Show me a loop"},
+		{Role: "assistant", Content: "```go\nfor i := 0; i < 3; i++ {}\n```"},
+	}
+
+	out, offsets := renderer.render(messages, 60, -1)
+	assert.Contains(t, out, "USER")
+	assert.Contains(t, out, "ASSISTANT")
+	assert.Contains(t, ansi.Strip(out), "Show me a loop")
+	assert.Equal(t, 2, len(renderer.cache))
+	assert.Equal(t, 0, offsets[0])
+	assert.Equal(t, "ASSISTANT", ansi.Strip(strings.Split(out, "\n")[offsets[1]]))
+
+	// Rendering again is served from the cache
+	again, _ := renderer.render(messages, 60, -1)
+	assert.Equal(t, out, again)
+	assert.Equal(t, 2, len(renderer.cache))
+
+	// Selecting a message numbers its code blocks
+	selected, _ := renderer.render(messages, 60, 1)
+	assert.Contains(t, ansi.Strip(selected), "[1]")
+
+	// A new width invalidates the cache
+	renderer.render(messages[:1], 40, -1)
+	assert.Equal(t, 40, renderer.width)
+	assert.Equal(t, 1, len(renderer.cache))
+}
+
+func TestCodeBlocks(t *testing.T) {
+	content := "Try this:\n\n```bash\nls -la\n```\n\nor\n\n~~~\nfind . -name '*.go'\nwc -l\n~~~\n"
+
+	blocks := extractCodeBlocks(content)
+	require.Equal(t, 2, len(blocks))
+	assert.Equal(t, codeBlock{Lang: "bash", Code: "ls -la"}, blocks[0])
+	assert.Equal(t, codeBlock{Code: "find . -name '*.go'\nwc -l"}, blocks[1])
+
+	numbered := numberCodeBlocks(content)
+	assert.Contains(t, numbered, "**[1]**\n\n```bash")
+	assert.Contains(t, numbered, "**[2]**\n\n~~~")
+	assert.Equal(t, blocks, extractCodeBlocks(numbered))
+
+	assert.Empty(t, extractCodeBlocks("no code here"))
+
+	// An unterminated block is numbered and extracted alike
+	open := "Run:\n\n```sh\nmake\n"
+	assert.Equal(t, []codeBlock{{Lang: "sh", Code: "make"}}, extractCodeBlocks(open))
+	assert.Contains(t, numberCodeBlocks(open), "**[1]**\n\n```sh")
+
+	// Tool output is shown as one code block
+	tool := messageMarkdown(models.Message{Role: "tool", Content: "a.txt\nb.txt\n"})
+	assert.Equal(t, []codeBlock{{Code: "a.txt\nb.txt"}}, extractCodeBlocks(tool))
+	assert.Contains(t, numberCodeBlocks(tool), "**[1]**")
+
+	assert.Equal(t, "\x1b]52;c;aGk=\a", osc52("hi", false))
+	assert.Equal(t, "\x1bPtmux;\x1b\x1b]52;c;aGk=\a\x1b\\", osc52("hi", true))
+}
+
+func TestTUILayout(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	initial, err := initialModel()
+	require.NoError(t, err)
+
+	var m tea.Model = initial
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	view := m.View()
+	assert.Equal(t, 40, lipgloss.Height(view))
+	assert.LessOrEqual(t, lipgloss.Width(view), 120)
+	assert.Contains(t, view, "Conversations")
+	assert.Contains(t, view, "Type your message")
+
+	// Tab moves the focus to the chat pane, which can hide the sidebar
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	assert.Equal(t, focusChat, m.(model).focus)
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	assert.NotContains(t, m.View(), "Conversations")
+
+	// Narrow terminals show one pane at a time
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 60, Height: 30})
+	view = m.View()
+	assert.NotContains(t, view, "Conversations")
+	assert.LessOrEqual(t, lipgloss.Width(view), 60)
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	view = m.View()
+	assert.Contains(t, view, "Conversations")
+	assert.NotContains(t, view, "Type your message")
+}
+
+func TestKeyMapAndThemeConfig(t *testing.T) {
+	defer func() {
+		viper.Set("keymap", "")
+		viper.Set("keys", nil)
+		viper.Set("theme", nil)
+	}()
+
+	for preset := range keyPresets {
+		viper.Set("keymap", preset)
+		_, err := loadKeyMap()
+		assert.NoError(t, err, preset)
+	}
+
+	viper.Set("keymap", "vim")
+	viper.Set("keys", map[string]any{"editor": []string{"ctrl+e"}})
+	keys, err := loadKeyMap()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ctrl+e"}, keys.Editor.Keys())
+	assert.Equal(t, "ctrl+e", keys.Editor.Help().Key)
+	assert.Contains(t, keys.Open.Keys(), "l")
+
+	viper.Set("keys", map[string]any{"send": []string{"tab"}})
+	_, err = loadKeyMap()
+	assert.ErrorContains(t, err, "tab is bound to both switch_focus and send in the chat")
+
+	viper.Set("keys", map[string]any{"launch": []string{"x"}})
+	_, err = loadKeyMap()
+	assert.ErrorContains(t, err, "unknown key action")
+
+	viper.Set("keys", nil)
+	viper.Set("keymap", "nano")
+	_, err = loadKeyMap()
+	assert.Error(t, err)
+
+	viper.Set("theme", map[string]any{"mode": "light", "user": "#ff8800"})
+	theme, err := loadTheme()
+	require.NoError(t, err)
+	assert.Equal(t, "light", theme.Mode)
+	assert.Equal(t, "#ff8800", theme.User)
+	assert.Equal(t, lightTheme.Assistant, theme.Assistant)
+
+	viper.Set("theme", map[string]any{"mode": "dark", "border": "purple"})
+	_, err = loadTheme()
+	assert.ErrorContains(t, err, "theme.border")
+
+	viper.Set("theme", map[string]any{"mode": "sepia"})
+	_, err = loadTheme()
+	assert.Error(t, err)
+}
+
+func TestTUIModelPickerAndParameters(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+
+	initial, err := initialModel()
+	require.NoError(t, err)
+	initial.session = newChatSession(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{})
+
+	var m tea.Model = initial
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+
+	m, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlO})
+	require.NotNil(t, cmd)
+	m, _ = m.Update(cmd())
+	view := m.View()
+	assert.Contains(t, view, "other-model")
+	assert.Contains(t, view, "loaded")
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	session := m.(model).session
+	assert.Equal(t, modalNone, m.(model).modal)
+	assert.Equal(t, "other-model", session.client.Model)
+	assert.Equal(t, "other-model", session.conversation.Model)
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlP})
+	assert.Equal(t, modalParams, m.(model).modal)
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("0.3")})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("4096")})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, modalNone, m.(model).modal)
+	assert.Equal(t, 0.3, *session.conversation.Temperature)
+	assert.Equal(t, 4096, *session.client.Options.NumCtx)
+	assert.Nil(t, session.client.Options.TopP)
+
+	// Reopening the conversation brings its settings back
+	require.NoError(t, saveConversation(session.conversation))
+	saved, err := db.GetConversation(session.conversation.ID)
+	require.NoError(t, err)
+	other := newChatSession(saved, testutils.NewTestOllamaClient(server), chatOptions{})
+	assert.Equal(t, "other-model", other.client.Model)
+	assert.Equal(t, 0.3, *other.client.Options.Temperature)
+	assert.Equal(t, 4096, *other.client.Options.NumCtx)
+	other.open(newConversation())
+	assert.Equal(t, "test-model", other.client.Model)
+	assert.Nil(t, other.client.Options.Temperature)
+	assert.Nil(t, other.client.Options.NumCtx)
+	require.NoError(t, db.DeleteConversation(saved.ID))
+
+	_, err = parseParams([]string{"3", "", "", ""})
+	assert.ErrorContains(t, err, "temperature")
+	_, err = parseParams([]string{"", "", "0", ""})
+	assert.ErrorContains(t, err, "num_ctx")
+	options, err := parseParams([]string{"", "0.9", "", "7"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.9, *options.TopP)
+	assert.Equal(t, 7, *options.Seed)
+}
+
+func TestCompareModels(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+
+	// The database is shared between runs, so the models get unique names
+	first, second := "first-"+newConversationID(), "second-"+newConversationID()
+
+	session := newChatSession(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{})
+	_, err := runSlashCommand(session, "/compare "+first)
+	assert.ErrorContains(t, err, "two models")
+	output, err := runSlashCommand(session, "/compare "+first+", "+second)
+	require.NoError(t, err)
+	assert.Contains(t, output, first+", "+second)
+
+	results, err := session.compare(context.Background(), "Which is faster?")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, second, results[1].Model)
+	assert.Equal(t, "I'm a test response", results[1].Content)
+	assert.Equal(t, 8, results[1].Usage.CompletionTokens)
+	assert.Empty(t, session.conversation.Messages)
+
+	assert.Error(t, session.pick(3))
+	require.NoError(t, session.pick(2))
+	assert.Nil(t, session.pending)
+	require.Len(t, session.conversation.Messages, 2)
+	assert.Equal(t, "I'm a test response", session.conversation.Messages[1].Content)
+
+	comparisons, err := db.GetAllComparisons()
+	require.NoError(t, err)
+	require.NotEmpty(t, comparisons)
+	assert.Equal(t, second, comparisons[0].Winner)
+	assert.Equal(t, session.conversation.ID, comparisons[0].ConversationID)
+	assert.Len(t, comparisons[0].Candidates, 2)
+
+	stats, err := db.GetModelStats()
+	require.NoError(t, err)
+	var out bytes.Buffer
+	printLeaderboard(&out, stats)
+	assert.Regexp(t, second+` +1 +1 +100%`, out.String())
+	assert.Regexp(t, first+` +0 +1 +0%`, out.String())
+
+	winner := askWinner(bufio.NewReader(strings.NewReader("7\n1\n")), io.Discard, 2)
+	assert.Equal(t, 1, winner)
+	assert.Equal(t, 0, askWinner(bufio.NewReader(strings.NewReader("\n")), io.Discard, 2))
+
+	// The TUI shows the replies in columns until one is picked
+	initial, err := initialModel()
+	require.NoError(t, err)
+	initial.session = session
+	var m tea.Model = initial
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("And now?")})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, modalCompare, m.(model).modal)
+	view := ansi.Strip(m.View())
+	assert.Contains(t, view, "1. "+first)
+	assert.Contains(t, view, "2. "+second)
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("1")})
+	assert.Equal(t, modalNone, m.(model).modal)
+	assert.Len(t, session.conversation.Messages, 4)
+	assert.Contains(t, m.(model).status, first)
+}
+
+func TestPromptTemplates(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set("template-dir", dir)
+	defer viper.Set("template-dir", "")
+
+	require.NoError(t, templates.Save(dir, "review", "{{/* Review code */}}\nReview this {{.lang}} code{{if .focus}} for {{.focus}}{{end}}."))
+	require.NoError(t, templates.Save(dir, "wrap", "Start\n{{.files}}Question: {{.input}}\nEnd"))
+
+	source := filepath.Join(t.TempDir(), "main.go")
+	require.NoError(t, os.WriteFile(source, []byte("package main\n"), 0644))
+
+	// Files are attached unless the template quotes them itself
+	prompt, attach, err := buildPrompt("review", []string{"lang=go"}, []string{source}, []string{"be", "brief"})
+	require.NoError(t, err)
+	assert.Equal(t, "Review this go code.\n\nbe brief", prompt)
+	assert.Equal(t, []string{source}, attach)
+
+	prompt, attach, err = buildPrompt("wrap", nil, []string{source}, []string{"why?"})
+	require.NoError(t, err)
+	assert.Equal(t, "Start\nFile "+source+":\n```\npackage main\n```\n\nQuestion: why?\nEnd", prompt)
+	assert.Empty(t, attach)
+
+	_, _, err = buildPrompt("review", nil, nil, nil)
+	assert.EqualError(t, err, "template review is missing variables: lang")
+	_, _, err = buildPrompt("review", []string{"lang"}, nil, nil)
+	assert.Error(t, err)
+	_, _, err = buildPrompt("missing", nil, nil, nil)
+	assert.ErrorContains(t, err, "not found")
+
+	list, err := templates.List(dir)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	listTemplates(&out, list)
+	assert.Contains(t, out.String(), "review - Review code [lang, focus]")
+	assert.Contains(t, out.String(), "  wrap\n")
+
+	// A new chat in the TUI offers the templates and asks for the variables
+	require.NoError(t, initTestDB())
+	m, err := initialModel()
+	require.NoError(t, err)
+	var tm tea.Model = m
+	tm, _ = tm.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyCtrlN})
+	assert.Equal(t, modalTemplates, tm.(model).modal)
+	assert.Contains(t, ansi.Strip(tm.View()), "Review code")
+
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, modalTemplateVars, tm.(model).modal)
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, modalTemplateVars, tm.(model).modal)
+	assert.Contains(t, tm.(model).status, "missing variables: lang")
+
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("rust")})
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, modalNone, tm.(model).modal)
+	assert.Equal(t, focusChat, tm.(model).focus)
+	assert.Equal(t, "Review this rust code.", tm.(model).input.Value())
+}
+
+func TestImageAttachments(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "A red pixel."}}]}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	picture := filepath.Join(dir, "pixel.png")
+	var png bytes.Buffer
+	require.NoError(t, pngEncode(&png))
+	require.NoError(t, os.WriteFile(picture, png.Bytes(), 0644))
+	notes := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(notes, []byte("text"), 0644))
+
+	_, err := loadImage(notes)
+	assert.ErrorContains(t, err, "not an image")
+	image, err := loadImage(picture)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", image.MimeType)
+	assert.Len(t, image.Hash, 64)
+
+	session := newChatSession(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{})
+	_, err = runSlashCommand(session, "/image "+picture)
+	require.NoError(t, err)
+	_, err = session.send(context.Background(), "What is this?")
+	require.NoError(t, err)
+
+	// The image goes as a content part of the prompt and is stored with it
+	content := requests[0]["messages"].([]interface{})[0].(map[string]interface{})["content"].([]interface{})
+	require.Len(t, content, 2)
+	assert.Equal(t, "image_url", content[1].(map[string]interface{})["type"])
+	stored, err := db.GetConversation(session.conversation.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Messages[0].Images, 1)
+	assert.Equal(t, "pixel.png", stored.Messages[0].Images[0].Filename)
+	assert.Contains(t, describeImages(stored.Messages[0]), "[image: pixel.png")
+
+	// /retry sends the image again, follow-ups keep it in the history
+	_, err = runSlashCommand(session, "/retry")
+	require.NoError(t, err)
+	assert.Len(t, session.images, 1)
+	_, err = session.send(context.Background(), session.resend)
+	require.NoError(t, err)
+	require.Len(t, session.conversation.Messages[0].Images, 1)
+
+	_, err = session.send(context.Background(), "Which colour?")
+	require.NoError(t, err)
+	history := requests[2]["messages"].([]interface{})
+	assert.IsType(t, []interface{}{}, history[0].(map[string]interface{})["content"])
+	assert.IsType(t, "", history[2].(map[string]interface{})["content"])
+
+	// The TUI attaches images from a path prompt and marks them in the chat
+	m, err := initialModel()
+	require.NoError(t, err)
+	m.session = newChatSession(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{})
+	var tm tea.Model = m
+	tm, _ = tm.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyTab})
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a"), Alt: true})
+	assert.Contains(t, ansi.Strip(tm.View()), "Attach image:")
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(picture)})
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Contains(t, tm.(model).status, "pixel.png will be attached")
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("Describe it")})
+	tm, _ = tm.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Contains(t, ansi.Strip(tm.View()), "[image: pixel.png")
+}
+
+// pngEncode writes a one pixel PNG.
+func pngEncode(w io.Writer) error {
+	img := imagepkg.NewRGBA(imagepkg.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	return pngpkg.Encode(w, img)
+}
+
+func TestFileAttachments(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "Looks fine."}}]}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(source, []byte("package main\n"), 0644))
+	binary := filepath.Join(dir, "data.bin")
+	require.NoError(t, os.WriteFile(binary, []byte{0xff, 0xfe, 0x00}, 0644))
+
+	session := newChatSession(newConversation(), testutils.NewTestOllamaClient(server), chatOptions{})
+	_, err := runSlashCommand(session, "/file "+source)
+	require.NoError(t, err)
+	_, err = runSlashCommand(session, "/file "+binary)
+	require.NoError(t, err)
+	_, err = runSlashCommand(session, "/file "+dir)
+	assert.ErrorContains(t, err, "is a directory")
+	_, err = session.send(context.Background(), "Review it")
+	require.NoError(t, err)
+
+	// The model gets the files quoted, the stored message keeps only the prompt
+	content := requests[0]["messages"].([]interface{})[0].(map[string]interface{})["content"].(string)
+	assert.True(t, strings.HasPrefix(content, "File "+source+":\n```\npackage main\n```\n\nFile "+binary+" ("))
+	assert.True(t, strings.HasSuffix(content, ", 3 B) is attached but not text.\n\nReview it"))
+	assert.Equal(t, "Review it", session.conversation.Messages[0].Content)
+
+	// Follow-ups send the attachments with the history
+	_, err = runSlashCommand(session, "/file "+source)
+	require.NoError(t, err)
+	_, err = session.send(context.Background(), "And again")
+	require.NoError(t, err)
+	history := requests[1]["messages"].([]interface{})
+	assert.Contains(t, history[0].(map[string]interface{})["content"], "package main")
+
+	attachments, err := db.GetAttachments(session.conversation.ID)
+	require.NoError(t, err)
+	require.Len(t, attachments, 3)
+	assert.Equal(t, attachments[0].BlobHash, attachments[2].BlobHash)
+
+	var out bytes.Buffer
+	listAttachments(&out, attachments)
+	assert.Contains(t, out.String(), fmt.Sprintf("%d. main.go (%s, 13 B) %s", attachments[0].ID, attachments[0].MimeType, attachments[0].BlobHash[:12]))
+	assert.Contains(t, describeAttachments(session.conversation.Messages[0]), "[file: main.go, 13 B]")
+
+	extracted := filepath.Join(t.TempDir(), "out")
+	written, err := extractAttachments(extracted, attachments)
+	require.NoError(t, err)
+	require.Len(t, written, 3)
+	assert.Equal(t, filepath.Join(extracted, "main.go"), written[0])
+	assert.Equal(t, filepath.Join(extracted, fmt.Sprintf("%d-main.go", attachments[2].ID)), written[2])
+	data, err := os.ReadFile(written[2])
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n", string(data))
+}
+
+func TestJSONOutput(t *testing.T) {
+	// The mock model forgets a required field once, then gets it right
+	replies := []string{`{"name": "termpilot"}`, "```json\n{\"name\": \"termpilot\", \"stars\": 3}\n```"}
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+
+		reply := replies[min(len(requests), len(replies))-1]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"message": map[string]string{"role": "assistant", "content": reply}}},
+		})
+	}))
+	defer server.Close()
+	client := testutils.NewTestOllamaClient(server)
+
+	schema := json.RawMessage(`{"type": "object", "properties": {"name": {"type": "string"}, "stars": {"type": "integer"}}, "required": ["name", "stars"]}`)
+
+	reply, err := completeJSON("Describe the repo", nil, chatOptions{system: "Be terse."}, client, schema, 2)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name": "termpilot", "stars": 3}`, reply)
+
+	require.Len(t, requests, 2)
+	format := requests[0]["response_format"].(map[string]interface{})
+	assert.Equal(t, "json_schema", format["type"])
+	messages := requests[1]["messages"].([]interface{})
+	require.Len(t, messages, 4)
+	assert.True(t, strings.HasPrefix(messages[0].(map[string]interface{})["content"].(string), "Be terse.\n\nReply only with JSON"))
+	assert.Contains(t, messages[3].(map[string]interface{})["content"], `missing required property "stars"`)
+
+	// Without retries the first reply fails validation
+	requests = nil
+	_, err = completeJSON("Describe the repo", nil, chatOptions{}, client, schema, 0)
+	assert.EqualError(t, err, `reply does not match the schema after 1 attempts: $: missing required property "stars"`)
+	assert.Len(t, requests, 1)
+
+	_, err = completeJSON("Describe the repo", nil, chatOptions{}, client, json.RawMessage(`{"type": "text"}`), 0)
+	assert.ErrorContains(t, err, "invalid schema")
+
+	assert.Equal(t, `{"a": 1}`, trimCodeFence("```\n{\"a\": 1}\n```"))
+}
+
+func TestBatch(t *testing.T) {
+	// The mock model echoes the model and the last message, and fails on "boom"
+	var mu sync.Mutex
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		mu.Lock()
+		requests = append(requests, request)
+		mu.Unlock()
+
+		messages := request["messages"].([]interface{})
+		last := messages[len(messages)-1].(map[string]interface{})["content"].(string)
+		if last == "boom" {
+			http.Error(w, "model crashed", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"message": map[string]string{"role": "assistant", "content": fmt.Sprintf("%s: %s", request["model"], last)}}},
+			"usage":   map[string]int{"prompt_tokens": 3, "completion_tokens": 2, "total_tokens": 5},
+		})
+	}))
+	defer server.Close()
+	client := testutils.NewTestOllamaClient(server)
+
+	input := strings.Join([]string{
+		`{"id": "a", "prompt": "first", "system": "Be brief", "options": {"temperature": 0.1}}`,
+		``,
+		`{"messages": [{"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}, {"role": "user", "content": "second"}], "model": "mistral"}`,
+		`{"id": "c", "prompt": "boom"}`,
+		`not json`,
+		`{"id": "e", "messages": [{"role": "assistant", "content": "no prompt"}]}`,
+	}, "\n")
+	items, err := readBatchItems(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, items, 5)
+	assert.Equal(t, "line-3", items[1].ID)
+	assert.Equal(t, "line-5", items[3].ID)
+
+	// A previous run answered "a", failed "c" and was cut off mid-line
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":"a","response":"done"}`+"\n"+`{"id":"c","error":"timeout"}`+"\n"+`{"id":"line-3","resp`), 0644))
+
+	out, done, err := openBatchOutput(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true}, done)
+
+	summary, err := runBatch(context.Background(), client, items, done, out, 2)
+	require.NoError(t, err)
+	require.NoError(t, out.Close())
+	assert.Equal(t, batchSummary{succeeded: 1, failed: 3, skipped: 1}, summary)
+	assert.Len(t, requests, 2)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	results := map[string]batchResult{}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	// The earlier failure of "c" is replaced by this run's line
+	require.Len(t, lines, 5)
+	for _, line := range lines {
+		var result batchResult
+		require.NoError(t, json.Unmarshal([]byte(line), &result), line)
+		assert.NotContains(t, results, result.ID)
+		results[result.ID] = result
+	}
+	assert.Equal(t, "mistral: second", results["line-3"].Response)
+	assert.Equal(t, 2, results["line-3"].Usage.CompletionTokens)
+	assert.NotEmpty(t, results["c"].Error)
+	assert.Contains(t, results["line-5"].Error, "invalid item")
+	assert.Contains(t, results["e"].Error, "needs a prompt")
+
+	// Every item is answered now except the failed ones
+	_, done, err = openBatchOutput(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true, "line-3": true}, done)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	// A cancelled run writes nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	_, err = runBatch(ctx, client, items[:1], nil, &buf, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, buf.String())
+}
+
+func TestServeProxy(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	// The mock Ollama echoes the last message, streamed in two chunks when asked
+	var requests []map[string]interface{}
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			w.Write([]byte(`{"object": "list", "data": [{"id": "test-model"}]}`))
+			return
+		}
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+
+		messages := request["messages"].([]interface{})
+		last := messageText(json.RawMessage(mustJSON(messages[len(messages)-1].(map[string]interface{})["content"])))
+		if request["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"choices\": [{\"delta\": {\"role\": \"assistant\", \"content\": \"echo \"}}]}\n\n")
+			fmt.Fprintf(w, "data: {\"choices\": [{\"delta\": {\"content\": %q}}]}\n\n", last)
+			fmt.Fprintf(w, "data: [DONE]\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"message": map[string]string{"role": "assistant", "content": "echo " + last}}},
+		})
+	}))
+	defer ollama.Close()
+
+	proxy := httptest.NewServer(newServer(testutils.NewTestOllamaClient(ollama), "").routes())
+	defer proxy.Close()
+
+	// Every run records under its own client name, as the database is shared
+	client := "test-" + newConversationID()
+	post := func(body string) string {
+		request, err := http.NewRequest(http.MethodPost, proxy.URL+"/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, err)
+		request.Header.Set("User-Agent", client+"/1.0 (linux)")
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		data, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	reply := post(`{"messages": [{"role": "system", "content": "Be brief"}, {"role": "user", "content": "hello"}]}`)
+	assert.Contains(t, reply, "echo hello")
+	assert.Equal(t, "test-model", requests[0]["model"])
+
+	// The follow-up sends the history again, streamed and with content parts
+	reply = post(`{"model": "other-model", "stream": true, "messages": [
+		{"role": "system", "content": "Be brief"},
+		{"role": "user", "content": "hello"},
+		{"role": "assistant", "content": "echo hello"},
+		{"role": "user", "content": [{"type": "text", "text": "again"}, {"type": "image_url", "image_url": {"url": "data:image/png;base64,AA=="}}]}
+	]}`)
+	assert.Contains(t, reply, `"content": "again"`)
+	assert.Contains(t, reply, "data: [DONE]")
+
+	// An unrelated history starts another conversation
+	post(`{"messages": [{"role": "user", "content": "hello"}]}`)
+	post(`{"messages": [{"role": "user", "content": "€€€€€€€€€€€€€€€€€€€€€€€€€"}]}`)
+
+	conversations, err := db.GetConversationsByTagPrefix(serveTagPrefix + client)
+	require.NoError(t, err)
+	require.Len(t, conversations, 3)
+	assert.Equal(t, strings.Repeat("€", 20), conversations[0].Title)
+	first, err := db.GetConversation(conversations[2].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Be brief", first.SystemPrompt)
+	assert.Equal(t, "hello", first.Title)
+	require.Len(t, first.Messages, 4)
+	assert.Equal(t, "again", first.Messages[2].Content)
+	assert.Equal(t, "echo again", first.Messages[3].Content)
+
+	response, err := http.Get(proxy.URL + "/v1/models")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	models, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(models), "test-model")
+
+	for _, body := range []string{"{", "null", "[]", `"hi"`} {
+		response, err = http.Post(proxy.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, body)
+	}
+}
+
+func mustJSON(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func TestConversationAPI(t *testing.T) {
+	require.NoError(t, db.OpenDB(filepath.Join(t.TempDir(), "api.db")))
+	t.Cleanup(func() { initTestDB() })
+
+	ollama := testutils.MockOllamaServer()
+	defer ollama.Close()
+	api := httptest.NewServer(newServer(testutils.NewTestOllamaClient(ollama), "secret").routes())
+	defer api.Close()
+
+	call := func(method string, path string, body string, out interface{}) int {
+		request, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer secret")
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		if out != nil {
+			require.NoError(t, json.NewDecoder(response.Body).Decode(out))
+		}
+		return response.StatusCode
+	}
+
+	var created apiConversation
+	assert.Equal(t, http.StatusCreated, call("POST", "/api/conversations", `{"prompt": "What is a goroutine?", "system": "Be brief", "model": "other-model"}`, &created))
+	assert.Equal(t, "What is a goroutine?"[:20], created.Title)
+	assert.Equal(t, "other-model", created.Model)
+	require.Len(t, created.Messages, 2)
+	assert.Equal(t, "I'm a test response", created.Messages[1].Content)
+
+	var added []apiMessage
+	assert.Equal(t, http.StatusOK, call("POST", "/api/conversations/"+created.ID+"/messages", `{"prompt": "And a channel?"}`, &added))
+	require.Len(t, added, 2)
+	assert.Equal(t, "And a channel?", added[0].Content)
+
+	var fetched apiConversation
+	assert.Equal(t, http.StatusOK, call("GET", "/api/conversations/"+created.ID, "", &fetched))
+	assert.Equal(t, "Be brief", fetched.SystemPrompt)
+	assert.Len(t, fetched.Messages, 4)
+
+	var listed []apiConversation
+	assert.Equal(t, http.StatusOK, call("GET", "/api/conversations", "", &listed))
+	require.Len(t, listed, 1)
+	assert.Empty(t, listed[0].Messages)
+	assert.Equal(t, http.StatusOK, call("GET", "/api/conversations?q=channel", "", &listed))
+	assert.Len(t, listed, 1)
+	assert.Equal(t, http.StatusOK, call("GET", "/api/conversations?q=mutex", "", &listed))
+	assert.Len(t, listed, 0)
+
+	assert.Equal(t, http.StatusBadRequest, call("POST", "/api/conversations", `{"prompt": " "}`, nil))
+	assert.Equal(t, http.StatusNotFound, call("GET", "/api/conversations/missing", "", nil))
+	assert.Equal(t, http.StatusNoContent, call("DELETE", "/api/conversations/"+created.ID, "", nil))
+	assert.Equal(t, http.StatusNotFound, call("DELETE", "/api/conversations/"+created.ID, "", nil))
+
+	// Requests without the token are refused, except for the description
+	response, err := http.Get(api.URL + "/api/conversations")
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	response, err = http.Get(api.URL + "/api/openapi.json")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	var spec map[string]interface{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&spec))
+	assert.Contains(t, spec["paths"], "/api/conversations/{id}/messages")
+}
+
+func TestOllamaSupervisorConfig(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set("base-url", "http://127.0.0.1")
+	viper.Set("port", "11500")
+	viper.Set("ollama-dir", dir)
+	defer func() {
+		viper.Set("base-url", "http://localhost")
+		viper.Set("port", "11434")
+		viper.Set("ollama-dir", "")
+	}()
+
+	supervisor := getSupervisor()
+	assert.Equal(t, "http://127.0.0.1:11500", supervisor.Endpoint())
+	assert.Equal(t, filepath.Join(dir, "ollama-11500.log"), supervisor.LogPath())
+
+	assert.Equal(t, "c\nd\n", string(tailLines([]byte("a\nb\nc\nd\n"), 2)))
+	assert.Equal(t, "a\nb", string(tailLines([]byte("a\nb"), 5)))
+	assert.Empty(t, tailLines(nil, 3))
+}
+
+func TestDoctor(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+	client := testutils.NewTestOllamaClient(server)
+
+	checks := runDoctor(client, ollamaclient.NewSupervisor(server.URL, "", t.TempDir()), nil)
+	statuses := map[string]string{}
+	for _, check := range checks {
+		statuses[check.name] = check.status
+	}
+	assert.Equal(t, map[string]string{"config": checkOK, "ollama": checkOK, "model": checkOK, "embed model": checkWarn, "database": checkOK}, statuses)
+
+	var out bytes.Buffer
+	assert.False(t, printChecks(&out, checks))
+	assert.Contains(t, out.String(), "test-model is available (954 MB), loaded")
+	assert.Contains(t, out.String(), "ollama pull "+client.EmbedModel)
+
+	// Nothing answers on a closed server
+	server.Close()
+	checks = runDoctor(client, ollamaclient.NewSupervisor(server.URL, "", t.TempDir()), fmt.Errorf("disk full"))
+	out.Reset()
+	assert.True(t, printChecks(&out, checks))
+	assert.Contains(t, out.String(), "not checked, ollama is not reachable")
+	assert.Contains(t, out.String(), "failed to open: disk full")
+}
+
+func TestQueuePendingPrompts(t *testing.T) {
+	require.NoError(t, initTestDB())
+
+	// Nothing listens on a closed server
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	offline := testutils.NewTestOllamaClient(down)
+
+	conversation := newConversation()
+	_, err := sendPrompt(context.Background(), conversation, "lost prompt", chatOptions{}, offline)
+	require.Error(t, err)
+	assert.True(t, isUnreachable(err))
+	assert.True(t, conversation.CreatedAt.IsZero())
+
+	_, err = sendPrompt(context.Background(), conversation, "first", chatOptions{queue: true}, offline)
+	var queued *queuedError
+	require.ErrorAs(t, err, &queued)
+	assert.Equal(t, conversation.ID, queued.conversationID)
+	_, err = sendPrompt(context.Background(), conversation, "second", chatOptions{queue: true, attachments: []models.Attachment{
+		{Filename: "notes.txt", MimeType: "text/plain", BlobHash: "queued-notes", Blob: models.Blob{Hash: "queued-notes", Content: []byte("notes")}},
+	}}, offline)
+	require.ErrorAs(t, err, &queued)
+
+	pending, err := db.GetPendingMessages()
+	require.NoError(t, err)
+	var ours []models.Message
+	for _, message := range pending {
+		if message.ConversationID == conversation.ID {
+			ours = append(ours, message)
+		}
+	}
+	require.Len(t, ours, 2)
+	var out bytes.Buffer
+	listPending(&out, ours)
+	assert.Contains(t, out.String(), "["+conversation.ID+" first] first (queued ")
+
+	out.Reset()
+	long := ours[0]
+	long.Content = strings.Repeat("ü", 70)
+	listPending(&out, []models.Message{long})
+	assert.Contains(t, out.String(), strings.Repeat("ü", 57)+"... (queued ")
+
+	// Pending prompts are not sent as history
+	assert.Empty(t, toClientMessages(conversation.Messages))
+
+	// Still down, the first prompt stays pending and is not queued twice
+	session := newChatSession(conversation, offline, chatOptions{queue: true})
+	sent, err := session.sendPending(context.Background())
+	assert.Equal(t, 0, sent)
+	assert.True(t, isUnreachable(err))
+	assert.Equal(t, 2, countPending(conversation.Messages))
+
+	// Once the server is back both are answered in order
+	server := testutils.MockOllamaServer()
+	defer server.Close()
+	session = newChatSession(conversation, testutils.NewTestOllamaClient(server), chatOptions{})
+	sent, err = session.sendPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	stored, err := db.GetConversation(conversation.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Messages, 4)
+	assert.Equal(t, 0, countPending(stored.Messages))
+	assert.Equal(t, "first", stored.Messages[0].Content)
+	assert.Equal(t, "second", stored.Messages[2].Content)
+	assert.Equal(t, "notes.txt", stored.Messages[2].Attachments[0].Filename)
+	assert.Equal(t, "I'm a test response", stored.Messages[3].Content)
+}
+
+func TestConfigProfiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "termpilot.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`model: file-model
+version: v2
+embed-model: file-embed
+profiles:
+  work:
+    model: work-model
+    queue: true
+`), 0o644))
+
+	empty := filepath.Join(dir, "empty.yaml")
+	require.NoError(t, os.WriteFile(empty, nil, 0o644))
+	t.Cleanup(func() {
+		cfgFile = empty
+		initConfig()
+		cfgFile = ""
+	})
+
+	cfgFile = path
+	initConfig()
+	cfg := currentConfig()
+	assert.Equal(t, "file-model", cfg.Model)
+	assert.Equal(t, "v2", cfg.Version)
+	assert.False(t, cfg.Queue)
+	assert.Equal(t, "config file", configSource("model"))
+
+	// The profile replaces the values of the file, the environment both
+	t.Setenv("TERMPILOT_PROFILE", "work")
+	t.Setenv("TERMPILOT_EMBED_MODEL", "env-embed")
+	initConfig()
+	cfg = currentConfig()
+	assert.Equal(t, "work-model", cfg.Model)
+	assert.Equal(t, "v2", cfg.Version)
+	assert.True(t, cfg.Queue)
+	assert.Equal(t, "env-embed", cfg.EmbedModel)
+	assert.Equal(t, "env-embed", getOllamaClient().EmbedModel)
+	assert.Equal(t, "profile work", configSource("model"))
+	assert.Equal(t, "env TERMPILOT_EMBED_MODEL", configSource("embed-model"))
+
+	var out bytes.Buffer
+	listConfig(&out)
+	assert.Contains(t, out.String(), "Profile: work\n")
+	assert.Contains(t, out.String(), "model = work-model (profile work)\n")
+
+	assert.Error(t, applyProfile("home"))
+}
+
+func TestConfigSetNewProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "termpilot.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("model: llama3.2\n"), 0o600))
+
+	empty := filepath.Join(dir, "empty.yaml")
+	require.NoError(t, os.WriteFile(empty, nil, 0o644))
+	t.Cleanup(func() {
+		for _, name := range []string{"profile", "config"} {
+			flag := rootCmd.PersistentFlags().Lookup(name)
+			flag.Value.Set("")
+			flag.Changed = false
+		}
+		rootCmd.SetArgs(nil)
+		cfgFile = empty
+		initConfig()
+		cfgFile = ""
+	})
+
+	// The profile does not exist yet, which only the other commands reject
+	rootCmd.SetArgs([]string{"--config", path, "--profile", "work", "config", "set", "model", "qwen2.5"})
+	require.NoError(t, rootCmd.Execute())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "model: llama3.2\nprofiles:\n  work:\n    model: qwen2.5\n", string(data))
+
+	initConfig()
+	assert.NoError(t, profileErr)
+	assert.Equal(t, "qwen2.5", currentConfig().Model)
+
+	require.NoError(t, rootCmd.PersistentFlags().Set("profile", "home"))
+	initConfig()
+	assert.ErrorContains(t, profileErr, `unknown profile "home"`)
+	assert.Equal(t, "llama3.2", currentConfig().Model)
+	var out bytes.Buffer
+	listConfig(&out)
+	assert.Contains(t, out.String(), "Profile not applied: unknown profile")
+	checks := runDoctor(getOllamaClient(), getSupervisor(), nil)
+	assert.Equal(t, checkFail, checks[0].status)
+}
+
+func TestConfigSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termpilot.yaml")
+
+	// New files are created private
+	require.NoError(t, setConfigValue(path, "model", "llama3.2"))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	require.NoError(t, os.WriteFile(path, []byte("# my settings\nmodel: llama3.2 # small\nport: \"11434\"\n"), 0o644))
+	require.NoError(t, os.Chmod(path, 0o644))
+	require.NoError(t, setConfigValue(path, "model", "qwen2.5"))
+	require.NoError(t, setConfigValue(path, "queue", "true"))
+	require.NoError(t, setConfigValue(path, "theme.user", "#5fafff"))
+	require.NoError(t, setConfigValue(path, "keys.editor", "[ctrl+e]"))
+	require.NoError(t, setConfigValue(path, "profiles.work.auto_start", "always"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# my settings
+model: qwen2.5 # small
+port: "11434"
+queue: true
+theme:
+  user: '#5fafff'
+keys:
+  editor: [ctrl+e]
+profiles:
+  work:
+    auto_start: always
+`, string(data))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	assert.ErrorContains(t, setConfigValue(path, "modle", "x"), "unknown config key")
+	assert.ErrorContains(t, setConfigValue(path, "profiles.work.profiles", "x"), "unknown config key")
+	assert.ErrorContains(t, setConfigValue(path, "auto_start", "sometimes"), "unknown auto_start value")
+	assert.ErrorContains(t, setConfigValue(path, "model.name", "x"), "unknown config key")
+	assert.ErrorContains(t, setConfigValue(path, "theme.user.dark", "x"), "unknown config key")
+
+	// A value that is not a section cannot hold keys
+	require.NoError(t, setConfigValue(path, "profiles.home", "none"))
+	assert.ErrorContains(t, setConfigValue(path, "profiles.home.model", "x"), "profiles.home is not a section")
+
+	// Failed edits leave the file alone
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(after), "home: none")
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
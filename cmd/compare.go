@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"termpilot/db"
+	"termpilot/models"
+	"termpilot/ollamaclient"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	compareCmd.Flags().String("models", "", "comma separated models to compare")
+	compareCmd.Flags().String("continue", "", "compare the next reply of a conversation")
+	compareCmd.Flags().Bool("no-vote", false, "show the replies without asking for a winner")
+
+	rootCmd.AddCommand(compareCmd)
+}
+
+// comparisonResult is the reply of one model in a comparison.
+type comparisonResult struct {
+	Model    string
+	Content  string
+	Duration time.Duration
+	Usage    ollamaclient.Usage
+	Err      error
+}
+
+// tokensPerSecond is the generation speed, or 0 when the server did not
+// report token usage.
+func (r comparisonResult) tokensPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Usage.CompletionTokens) / r.Duration.Seconds()
+}
+
+func (r comparisonResult) stats() string {
+	if r.Err != nil {
+		return "failed"
+	}
+	return fmt.Sprintf("%.1fs · %d tokens · %.1f tok/s", r.Duration.Seconds(), r.Usage.CompletionTokens, r.tokensPerSecond())
+}
+
+func parseModelList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// compareModels sends prompt after history to every model at once and
+// returns the replies in the order of modelNames.
+func compareModels(ctx context.Context, client *ollamaclient.OllamaClient, modelNames []string, history []models.Message, prompt string) []comparisonResult {
+	messages := toClientMessages(history)
+
+	results := make([]comparisonResult, len(modelNames))
+	var wg sync.WaitGroup
+	for i, name := range modelNames {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			modelClient := *client
+			modelClient.Model = name
+
+			results[i].Model = name
+			completion, err := modelClient.ChatCompletionStats(ctx, prompt, messages)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].Content = completion.Content
+			results[i].Duration = completion.Duration
+			results[i].Usage = completion.Usage
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+type pendingComparison struct {
+	prompt      string
+	attachments []models.Attachment
+	results     []comparisonResult
+}
+
+// compare runs a comparison in the session's conversation. The replies wait
+// for pick before one of them joins the conversation.
+func (s *chatSession) compare(ctx context.Context, prompt string) ([]comparisonResult, error) {
+	if len(s.compareModels) < 2 {
+		return nil, fmt.Errorf("compare needs at least two models")
+	}
+
+	history := s.conversation.Messages
+	if s.conversation.SystemPrompt != "" {
+		history = append([]models.Message{{Role: "system", Content: s.conversation.SystemPrompt}}, history...)
+	}
+
+	attachments := s.attachments
+	s.attachments = nil
+
+	results := compareModels(ctx, s.client, s.compareModels, history, attachmentText(attachments)+prompt)
+	s.pending = &pendingComparison{prompt: prompt, attachments: attachments, results: results}
+	return results, nil
+}
+
+// pick records the vote for the pending comparison and keeps the reply of
+// the winner, numbered from 1, in the conversation. 0 records no winner.
+func (s *chatSession) pick(winner int) error {
+	if s.pending == nil {
+		return fmt.Errorf("no comparison to pick from")
+	}
+	pending := s.pending
+	if winner < 0 || winner > len(pending.results) {
+		return fmt.Errorf("pick a reply between 1 and %d, or 0 for none", len(pending.results))
+	}
+	if winner > 0 && pending.results[winner-1].Err != nil {
+		return fmt.Errorf("%s failed and cannot win", pending.results[winner-1].Model)
+	}
+
+	comparison := models.Comparison{Prompt: pending.prompt}
+	for _, result := range pending.results {
+		candidate := models.Candidate{
+			Model:            result.Model,
+			Content:          result.Content,
+			LatencyMs:        result.Duration.Milliseconds(),
+			CompletionTokens: result.Usage.CompletionTokens,
+		}
+		if result.Err != nil {
+			candidate.Error = result.Err.Error()
+		}
+		comparison.Candidates = append(comparison.Candidates, candidate)
+	}
+
+	if winner > 0 {
+		kept := pending.results[winner-1]
+		comparison.Winner = kept.Model
+
+		if s.conversation.Title == "" {
+			s.conversation.Title = firstRunes(pending.prompt, 20)
+		}
+		s.conversation.Messages = append(s.conversation.Messages,
+			models.Message{Role: "user", Content: pending.prompt, Attachments: pending.attachments},
+			models.Message{Role: "assistant", Content: kept.Content},
+		)
+		if err := saveConversation(s.conversation); err != nil {
+			return err
+		}
+		comparison.ConversationID = s.conversation.ID
+	}
+
+	if _, err := db.SaveComparison(comparison); err != nil {
+		return err
+	}
+	s.pending = nil
+	return nil
+}
+
+func printComparison(out io.Writer, results []comparisonResult) {
+	for i, result := range results {
+		fmt.Fprint(out, fancyPrint(fmt.Sprintf("## %d. %s\n\n*%s*", i+1, result.Model, result.stats())))
+		if result.Err != nil {
+			fmt.Fprintf(out, "Error: %v\n", result.Err)
+			continue
+		}
+		fmt.Fprint(out, fancyPrint(result.Content))
+	}
+}
+
+// askWinner reads the number of the winning reply, 0 meaning none.
+func askWinner(in *bufio.Reader, out io.Writer, count int) int {
+	for {
+		fmt.Fprintf(out, "Pick a winner (1-%d, Enter for none): ", count)
+		answer, err := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return 0
+		}
+		if winner, convErr := strconv.Atoi(answer); convErr == nil && winner >= 0 && winner <= count {
+			return winner
+		}
+		if err != nil {
+			return 0
+		}
+	}
+}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare <prompt>",
+	Short: "Send a prompt to several models and vote for the best reply",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		modelList, err := cmd.Flags().GetString("models")
+		if err != nil {
+			log.Fatalf("Failed to get models: %v", err)
+		}
+
+		conversationId, err := cmd.Flags().GetString("continue")
+		if err != nil {
+			log.Fatalf("Failed to get continue: %v", err)
+		}
+
+		noVote, err := cmd.Flags().GetBool("no-vote")
+		if err != nil {
+			log.Fatalf("Failed to get no-vote: %v", err)
+		}
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		conversation := newConversation()
+		if conversationId != "" {
+			if conversation, err = db.GetConversation(conversationId); err != nil {
+				log.Fatalf("Failed to get conversation: %v", err)
+			}
+		}
+
+		session := newChatSession(conversation, getOllamaClient(), chatOptions{})
+		session.compareModels = parseModelList(modelList)
+
+		results, err := session.compare(cmd.Context(), strings.Join(args, " "))
+		if err != nil {
+			log.Fatalf("Failed to compare models: %v", err)
+		}
+		printComparison(os.Stdout, results)
+
+		winner := 0
+		if !noVote {
+			winner = askWinner(bufio.NewReader(os.Stdin), os.Stdout, len(results))
+		}
+		if err := session.pick(winner); err != nil {
+			log.Fatalf("Failed to record the comparison: %v", err)
+		}
+		if winner > 0 {
+			fmt.Printf("Kept the reply of %s in conversation %s\n", results[winner-1].Model, session.conversation.ID)
+		}
+	},
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// sendComparison sends prompt to the compare models of the session and
+// shows the replies side by side until one is picked.
+func sendComparison(m model, prompt string) (model, tea.Cmd) {
+	if _, err := m.session.compare(context.Background(), prompt); err != nil {
+		m.status = "Error: " + err.Error()
+		m.input.SetValue(prompt)
+		return m, nil
+	}
+
+	m.status = ""
+	m.modal = modalCompare
+	m = layout(m)
+	m.comparison.GotoTop()
+	return m, nil
+}
+
+// comparisonColumns renders the pending replies in columns filling width.
+func comparisonColumns(m model, width int) string {
+	pending := m.session.pending
+	if pending == nil {
+		return ""
+	}
+
+	frameWidth, _ := m.styles.pane.GetFrameSize()
+	columnWidth := max(width/len(pending.results)-frameWidth, 10)
+
+	columns := make([]string, len(pending.results))
+	for i, result := range pending.results {
+		header := m.styles.header.Render(fmt.Sprintf("%d. %s", i+1, result.Model))
+		content := "Error: " + fmt.Sprint(result.Err)
+		if result.Err == nil {
+			content = m.columns.renderMarkdown(result.Content, columnWidth)
+		}
+		columns[i] = m.styles.pane.Width(columnWidth).Render(
+			lipgloss.JoinVertical(lipgloss.Left, header, result.stats(), "", content),
+		)
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Top, columns...)
+}
+
+// pickWinner keeps reply winner, 0 keeping none, and closes the comparison.
+func pickWinner(m model, winner int) (tea.Model, tea.Cmd) {
+	status := "Recorded the comparison without a winner"
+	if pending := m.session.pending; pending != nil && winner > 0 && winner <= len(pending.results) {
+		status = "Kept the reply of " + pending.results[winner-1].Model
+	}
+	if err := m.session.pick(winner); err != nil {
+		m.status = "Error: " + err.Error()
+		return m, nil
+	}
+
+	m.status = status
+	m.modal = modalNone
+	m = showMessages(m, m.session.conversation.Messages)
+	return refreshConversations(layout(m)), nil
+}
+
+func updateComparison(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Back):
+		return pickWinner(m, 0)
+	case key.Matches(msg, m.keys.Up):
+		m.comparison.LineUp(1)
+		return m, nil
+	case key.Matches(msg, m.keys.Down):
+		m.comparison.LineDown(1)
+		return m, nil
+	}
+
+	if winner, err := strconv.Atoi(msg.String()); err == nil {
+		return pickWinner(m, winner)
+	}
+
+	var cmd tea.Cmd
+	m.comparison, cmd = m.comparison.Update(msg)
+	return m, cmd
+}
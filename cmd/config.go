@@ -0,0 +1,471 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"termpilot/ollamaclient"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables overriding config values,
+// like TERMPILOT_BASE_URL for base-url.
+const envPrefix = "TERMPILOT"
+
+var envKeyReplacer = strings.NewReplacer("-", "_", ".", "_")
+
+// config holds the values shared by all commands, resolved from the flags,
+// the environment, the selected profile and the config file, in that order.
+type config struct {
+	Model       string
+	BaseURL     string
+	Port        string
+	Version     string
+	EmbedModel  string
+	EmbedAPI    string
+	AutoStart   string
+	StopOllama  bool
+	OllamaDir   string
+	Queue       bool
+	RagIndex    string
+	TemplateDir string
+	ServeToken  string
+}
+
+// configKeys are the keys of config, in the order config list shows them.
+var configKeys = []string{
+	"model", "base-url", "port", "version", "embed-model", "embed-api",
+	"auto_start", "stop-ollama", "ollama-dir", "queue", "rag-index",
+	"template-dir", "serve-token",
+}
+
+// activeProfile is the name and the values of the profile applied on top of
+// the config file, if any.
+var activeProfile struct {
+	name   string
+	values map[string]any
+}
+
+// profileErr tells why the selected profile could not be applied.
+var profileErr error
+
+func init() {
+	configInitCmd.Flags().Bool("force", false, "overwrite an existing config file")
+
+	configCmd.AddCommand(configGetCmd, configSetCmd, configListCmd, configPathCmd, configInitCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func currentConfig() config {
+	return config{
+		Model:       viper.GetString("model"),
+		BaseURL:     viper.GetString("base-url"),
+		Port:        viper.GetString("port"),
+		Version:     viper.GetString("version"),
+		EmbedModel:  viper.GetString("embed-model"),
+		EmbedAPI:    viper.GetString("embed-api"),
+		AutoStart:   viper.GetString("auto_start"),
+		StopOllama:  viper.GetBool("stop-ollama"),
+		OllamaDir:   viper.GetString("ollama-dir"),
+		Queue:       viper.GetBool("queue"),
+		RagIndex:    viper.GetString("rag-index"),
+		TemplateDir: viper.GetString("template-dir"),
+		ServeToken:  viper.GetString("serve-token"),
+	}
+}
+
+func (c config) ollamaClient() *ollamaclient.OllamaClient {
+	client := ollamaclient.NewOllamaClient(c.BaseURL, c.Model, c.Port, c.Version)
+	if c.EmbedModel != "" {
+		client.EmbedModel = c.EmbedModel
+	}
+	if c.EmbedAPI != "" {
+		client.EmbedAPI = c.EmbedAPI
+	}
+	return client
+}
+
+func getOllamaClient() *ollamaclient.OllamaClient {
+	return currentConfig().ollamaClient()
+}
+
+// applyProfile merges the profiles.<name> section over the config file.
+func applyProfile(name string) error {
+	activeProfile.name, activeProfile.values = "", nil
+	if name == "" {
+		return nil
+	}
+	key := "profiles." + name
+	if !viper.IsSet(key) {
+		return fmt.Errorf("unknown profile %q, add it under profiles in %s", name, configPathOrDefault())
+	}
+	values := viper.GetStringMap(key)
+	if err := viper.MergeConfigMap(values); err != nil {
+		return err
+	}
+	activeProfile.name, activeProfile.values = name, values
+	return nil
+}
+
+// envName is the environment variable overriding key.
+func envName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(envKeyReplacer.Replace(key))
+}
+
+// configSource tells where the value of key comes from.
+func configSource(key string) string {
+	if flag := rootCmd.PersistentFlags().Lookup(key); flag != nil && flag.Changed {
+		return "flag --" + key
+	}
+	if _, ok := os.LookupEnv(envName(key)); ok {
+		return "env " + envName(key)
+	}
+	if _, ok := activeProfile.values[key]; ok {
+		return "profile " + activeProfile.name
+	}
+	if viper.InConfig(key) {
+		return "config file"
+	}
+	return "default"
+}
+
+// configPath is the config file in use, or where it would be.
+func configPath() (string, error) {
+	if path := viper.ConfigFileUsed(); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".termpilot.yaml"), nil
+}
+
+func configPathOrDefault() string {
+	path, err := configPath()
+	if err != nil {
+		return "the config file"
+	}
+	return path
+}
+
+// checkConfigKey reports keys that are not config values, or not sections
+// and values within them.
+func checkConfigKey(key string) error {
+	parts := strings.Split(strings.ToLower(key), ".")
+	for _, configKey := range configKeys {
+		if parts[0] == configKey && len(parts) == 1 {
+			return nil
+		}
+	}
+	switch parts[0] {
+	case "keymap", "profile":
+		if len(parts) == 1 {
+			return nil
+		}
+	case "keys", "theme":
+		if len(parts) <= 2 {
+			return nil
+		}
+	case "profiles":
+		if len(parts) == 2 {
+			return nil
+		}
+		if len(parts) > 2 && parts[2] != "profile" && parts[2] != "profiles" && checkConfigKey(strings.Join(parts[2:], ".")) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown config key %q, see termpilot config list", key)
+}
+
+// checkConfigValue reports values the commands would reject later.
+func checkConfigValue(key, value string) error {
+	parts := strings.Split(strings.ToLower(key), ".")
+	if parts[0] == "profiles" && len(parts) > 2 {
+		parts = parts[2:]
+	}
+	switch strings.Join(parts, ".") {
+	case "auto_start":
+		switch value {
+		case ollamaclient.AutoStartAsk, ollamaclient.AutoStartAlways, ollamaclient.AutoStartNever:
+		default:
+			return fmt.Errorf("unknown auto_start value %q, use always, never or ask", value)
+		}
+	case "keymap":
+		if _, ok := keyPresets[value]; !ok {
+			return fmt.Errorf("unknown keymap %q, expected default, vim or emacs", value)
+		}
+	}
+	return nil
+}
+
+// setYAMLValue sets the dotted key in the YAML document data, creating the
+// sections it needs and keeping the comments and order of the rest. value
+// is parsed as YAML, so true is a boolean and [a, b] a list, and read as a
+// string when it is not valid YAML.
+func setYAMLValue(data []byte, key, value string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		comment := doc.HeadComment
+		doc = yaml.Node{Kind: yaml.DocumentNode, HeadComment: comment, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("the config file does not hold a mapping")
+	}
+
+	var parsed yaml.Node
+	newValue := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	if err := yaml.Unmarshal([]byte(value), &parsed); err == nil && len(parsed.Content) > 0 {
+		newValue = parsed.Content[0]
+	}
+
+	node := doc.Content[0]
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		var child *yaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if strings.EqualFold(node.Content[j].Value, part) {
+				child = node.Content[j+1]
+				break
+			}
+		}
+
+		if i == len(parts)-1 {
+			if child != nil {
+				newValue.HeadComment, newValue.LineComment = child.HeadComment, child.LineComment
+				*child = *newValue
+			} else {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part}, newValue)
+			}
+			break
+		}
+
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part}, child)
+		} else if child.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s is not a section", strings.Join(parts[:i+1], "."))
+		}
+		node = child
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so a failed write never leaves it truncated. It keeps the
+// mode of an existing file and makes new ones private, as config files may
+// hold tokens.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// setConfigValue sets key to value in the config file at path, creating it
+// if needed.
+func setConfigValue(path, key, value string) error {
+	if err := checkConfigKey(key); err != nil {
+		return err
+	}
+	if err := checkConfigValue(key, value); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	updated, err := setYAMLValue(data, strings.ToLower(key), value)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return writeFileAtomic(path, updated)
+}
+
+// listConfig prints the config values in use with where they come from.
+func listConfig(out io.Writer) {
+	if activeProfile.name != "" {
+		fmt.Fprintf(out, "Profile: %s\n", activeProfile.name)
+	}
+	if profileErr != nil {
+		fmt.Fprintf(out, "Profile not applied: %v\n", profileErr)
+	}
+	for _, key := range configKeys {
+		value := viper.GetString(key)
+		if key == "serve-token" && value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(out, "%s = %s (%s)\n", key, value, configSource(key))
+	}
+}
+
+// defaultConfigFile is written by config init.
+const defaultConfigFile = `# termpilot configuration, see termpilot config list for the values in use.
+# Flags override these values, and so do TERMPILOT_<KEY> environment
+# variables, like TERMPILOT_BASE_URL for base-url.
+
+model: llama3.2
+base-url: http://localhost
+port: "11434"
+auto_start: ask
+
+# Profiles group values used together, selected with --profile, the
+# TERMPILOT_PROFILE variable or the profile key:
+#
+# profile: work
+# profiles:
+#   work:
+#     base-url: http://gpu-box
+#     model: qwen2.5-coder
+`
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show and edit the configuration",
+	Long: `Values are read from the flags, then from TERMPILOT_<KEY> environment
+variables, then from the selected profile and finally from the config file
+(~/.termpilot.yaml unless --config is given).
+
+Profiles are sections under profiles in the config file, selected with
+--profile, TERMPILOT_PROFILE or the profile key, whose values replace those
+at the top of the file.`,
+	// Editing the config needs no database
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value in use for a key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkConfigKey(args[0]); err != nil {
+			log.Fatalf("Failed to get %s: %v", args[0], err)
+		}
+
+		switch value := viper.Get(args[0]).(type) {
+		case nil:
+		case map[string]any, []any:
+			out, err := yaml.Marshal(value)
+			if err != nil {
+				log.Fatalf("Failed to format %s: %v", args[0], err)
+			}
+			fmt.Print(string(out))
+		default:
+			fmt.Println(viper.GetString(args[0]))
+		}
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a value in the config file",
+	Long: `Set a value in the config file, keeping its comments. Keys of sections are
+joined with dots, like theme.user or profiles.work.model; with --profile the
+key is set in that profile.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := configPath()
+		if err != nil {
+			log.Fatalf("Failed to find the config file: %v", err)
+		}
+
+		key := args[0]
+		if flag := rootCmd.PersistentFlags().Lookup("profile"); flag.Changed {
+			key = "profiles." + flag.Value.String() + "." + key
+		}
+		if err := setConfigValue(path, key, args[1]); err != nil {
+			log.Fatalf("Failed to set %s: %v", key, err)
+		}
+		fmt.Printf("Set %s in %s\n", key, path)
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the values in use and where they come from",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		listConfig(os.Stdout)
+	},
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the config file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := configPath()
+		if err != nil {
+			log.Fatalf("Failed to find the config file: %v", err)
+		}
+		fmt.Println(path)
+	},
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a commented config file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			log.Fatalf("Failed to get force: %v", err)
+		}
+
+		path, err := configPath()
+		if err != nil {
+			log.Fatalf("Failed to find the config file: %v", err)
+		}
+		if _, err := os.Stat(path); err == nil && !force {
+			log.Fatalf("%s already exists, use --force to overwrite it", path)
+		}
+		if err := writeFileAtomic(path, []byte(defaultConfigFile)); err != nil {
+			log.Fatalf("Failed to write config file: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	},
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"termpilot/db"
+	"termpilot/models"
+	"termpilot/ollamaclient"
+	"termpilot/shell"
+
+	"github.com/spf13/cobra"
+)
+
+const maxFeedbackBytes = 4000
+
+type commandSuggestion struct {
+	Command     string `json:"command"`
+	Explanation string `json:"explanation"`
+}
+
+var suggestionSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"command": {"type": "string"},
+		"explanation": {"type": "string"}
+	},
+	"required": ["command", "explanation"]
+}`)
+
+func init() {
+	doCmd.Flags().Bool("dry-run", false, "only show the suggested command")
+	doCmd.Flags().BoolP("yes", "y", false, "run low and medium risk commands without asking")
+	doCmd.Flags().Int("follow-up", 0, "number of corrected attempts to ask for when the command fails")
+
+	rootCmd.AddCommand(doCmd)
+}
+
+func doSystemPrompt() string {
+	return fmt.Sprintf(`You turn tasks into a single shell command for %s on %s, run in the current directory.
+Reply only with a JSON object with the fields "command" (the command line to run) and "explanation" (one or two sentences on what it does).
+Prefer safe, non-destructive commands and never add commands the task did not ask for.`, shell.Shell(), runtime.GOOS)
+}
+
+// parseSuggestion decodes the model's reply, tolerating a surrounding code fence.
+func parseSuggestion(reply string) (*commandSuggestion, error) {
+	var suggestion commandSuggestion
+	if err := json.Unmarshal([]byte(trimCodeFence(reply)), &suggestion); err != nil {
+		return nil, fmt.Errorf("model did not reply with a command: %v", err)
+	}
+	if strings.TrimSpace(suggestion.Command) == "" {
+		return nil, fmt.Errorf("model replied with an empty command")
+	}
+	return &suggestion, nil
+}
+
+// confirmCommand asks before running a command. High risk commands need the
+// full word "yes".
+func confirmCommand(in *bufio.Reader, out io.Writer, risk shell.Risk) bool {
+	if risk == shell.RiskHigh {
+		fmt.Fprint(out, "This command is potentially destructive. Type 'yes' to run it: ")
+	} else {
+		fmt.Fprint(out, "Run this command? (y/n): ")
+	}
+
+	answer, _ := in.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if risk == shell.RiskHigh {
+		return answer == "yes"
+	}
+	return answer == "y" || answer == "Y" || answer == "yes"
+}
+
+// truncateTail keeps the end of long command output, where errors usually are,
+// starting on a character boundary so the model gets valid UTF-8.
+func truncateTail(output string) string {
+	if len(output) <= maxFeedbackBytes {
+		return output
+	}
+	start := len(output) - maxFeedbackBytes
+	for start < len(output) && !utf8.RuneStart(output[start]) {
+		start++
+	}
+	return "[truncated]\n" + output[start:]
+}
+
+func formatCommandResult(result *shell.Result) string {
+	return fmt.Sprintf("I ran `%s`. Exit code: %d\nstdout:\n%s\nstderr:\n%s",
+		result.Command, result.ExitCode, truncateTail(result.Stdout), truncateTail(result.Stderr))
+}
+
+func printSuggestion(suggestion *commandSuggestion, risk shell.Risk, reasons []string) {
+	fmt.Printf("\nCommand:     %s\n", suggestion.Command)
+	fmt.Printf("Explanation: %s\n", suggestion.Explanation)
+	fmt.Printf("Risk:        %s", risk)
+	if len(reasons) > 0 {
+		fmt.Printf(" (%s)", strings.Join(reasons, ", "))
+	}
+	fmt.Print("\n\n")
+}
+
+var doCmd = &cobra.Command{
+	Use:   "do <task>",
+	Short: "Ask for a shell command that performs a task and run it after confirmation",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalf("Failed to get dry-run: %v", err)
+		}
+
+		yes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			log.Fatalf("Failed to get yes: %v", err)
+		}
+
+		followUps, err := cmd.Flags().GetInt("follow-up")
+		if err != nil {
+			log.Fatalf("Failed to get follow-up: %v", err)
+		}
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		ollamaClient := getOllamaClient()
+		task := strings.Join(args, " ")
+		stdin := bufio.NewReader(os.Stdin)
+
+		messages := []ollamaclient.Message{
+			{Role: "system", Content: doSystemPrompt()},
+			{Role: "user", Content: task},
+		}
+
+		for attempt := 0; attempt <= followUps; attempt++ {
+			reply, err := ollamaClient.ChatCompletionJSON(messages, suggestionSchema)
+			if err != nil {
+				log.Fatalf("Failed to get response: %v", err)
+			}
+			messages = append(messages, ollamaclient.Message{Role: "assistant", Content: reply})
+
+			suggestion, err := parseSuggestion(reply)
+			if err != nil {
+				log.Fatalf("Failed to parse suggestion: %v", err)
+			}
+
+			risk, reasons := shell.Classify(suggestion.Command)
+			printSuggestion(suggestion, risk, reasons)
+
+			if dryRun {
+				break
+			}
+
+			if !(yes && risk < shell.RiskHigh) && !confirmCommand(stdin, os.Stdout, risk) {
+				fmt.Println("Not running the command.")
+				break
+			}
+
+			result, err := shell.Run(cmd.Context(), suggestion.Command, os.Stdout, os.Stderr)
+			if err != nil {
+				log.Fatalf("Failed to run command: %v", err)
+			}
+			fmt.Printf("\nExit code: %d\n", result.ExitCode)
+
+			feedback := formatCommandResult(result)
+			if result.ExitCode != 0 && attempt < followUps {
+				feedback += "\nThe command failed. Suggest a corrected command for the original task."
+				fmt.Println("Asking for a corrected command...")
+			}
+			messages = append(messages, ollamaclient.Message{Role: "user", Content: feedback})
+
+			if result.ExitCode == 0 {
+				break
+			}
+		}
+
+		// Keep the exchange, without the system prompt, as a conversation
+		var stored []models.Message
+		for _, message := range messages[1:] {
+			stored = append(stored, models.Message{Role: message.Role, Content: message.Content})
+		}
+		title := "do: " + task
+		db.CreateConversation(models.Conversation{
+			ID:       newConversationID(),
+			Title:    firstRunes(title, 20),
+			Messages: stored,
+		})
+	},
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"termpilot/db"
+	"termpilot/ollamaclient"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "FAIL"
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+type doctorCheck struct {
+	name   string
+	status string
+	detail string
+}
+
+// hasModel reports whether name is among models, where a name without a tag
+// stands for the latest one.
+func hasModel(models []ollamaclient.ModelInfo, name string) (ollamaclient.ModelInfo, bool) {
+	for _, model := range models {
+		if model.Name == name || model.Name == name+":latest" {
+			return model, true
+		}
+	}
+	return ollamaclient.ModelInfo{}, false
+}
+
+// runDoctor checks the configuration, the Ollama server, the configured
+// models and the database, which dbErr says failed to open.
+func runDoctor(client *ollamaclient.OllamaClient, supervisor *ollamaclient.Supervisor, dbErr error) []doctorCheck {
+	var checks []doctorCheck
+
+	config := doctorCheck{name: "config", status: checkOK, detail: "no config file, using defaults"}
+	if path := viper.ConfigFileUsed(); path != "" {
+		config.detail = path
+	}
+	switch autoStart := currentConfig().AutoStart; autoStart {
+	case "", ollamaclient.AutoStartAsk, ollamaclient.AutoStartAlways, ollamaclient.AutoStartNever:
+	default:
+		config.status = checkFail
+		config.detail = fmt.Sprintf("unknown auto_start value %q, use always, never or ask", autoStart)
+	}
+	if profileErr != nil {
+		config.status = checkFail
+		config.detail = profileErr.Error()
+	}
+	checks = append(checks, config)
+
+	start := time.Now()
+	running := supervisor.Running()
+	server := doctorCheck{name: "ollama", status: checkOK, detail: fmt.Sprintf("reachable at %s (%s)", supervisor.Endpoint(), time.Since(start).Round(time.Millisecond))}
+	if !running {
+		server.status = checkFail
+		server.detail = fmt.Sprintf("not reachable at %s, start it with termpilot ollama start", supervisor.Endpoint())
+	}
+	if pid, err := supervisor.PID(); err == nil && pid != 0 {
+		server.detail += fmt.Sprintf(", started by termpilot with PID %d", pid)
+	}
+	checks = append(checks, server)
+
+	// A missing chat model breaks every command, a missing embedding model
+	// only indexing and RAG
+	models := []struct {
+		name    string
+		model   string
+		missing string
+	}{
+		{"model", client.Model, checkFail},
+		{"embed model", client.EmbedModel, checkWarn},
+	}
+	var available []ollamaclient.ModelInfo
+	var listErr error
+	if running {
+		available, listErr = client.ListModelDetails()
+	}
+	for _, m := range models {
+		check := doctorCheck{name: m.name}
+		model, found := hasModel(available, m.model)
+		switch {
+		case !running:
+			check.status, check.detail = checkWarn, "not checked, ollama is not reachable"
+		case listErr != nil:
+			check.status, check.detail = checkFail, fmt.Sprintf("failed to list models: %v", listErr)
+		case !found:
+			check.status, check.detail = m.missing, fmt.Sprintf("%s is not available, run ollama pull %s", m.model, m.model)
+		default:
+			check.status, check.detail = checkOK, fmt.Sprintf("%s is available (%s)", model.Name, formatSize(model.Size))
+			if model.Loaded {
+				check.detail += ", loaded"
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	database := doctorCheck{name: "database", status: checkFail}
+	if dbErr != nil {
+		database.detail = fmt.Sprintf("failed to open: %v", dbErr)
+	} else if count, err := db.Check(); err != nil {
+		database.detail = err.Error()
+	} else {
+		database.status, database.detail = checkOK, fmt.Sprintf("%d conversations, integrity ok", count)
+	}
+	checks = append(checks, database)
+
+	return checks
+}
+
+// printChecks lists the checks and reports whether any failed.
+func printChecks(out io.Writer, checks []doctorCheck) bool {
+	failed := false
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, check := range checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.status, check.name, check.detail)
+		failed = failed || check.status == checkFail
+	}
+	w.Flush()
+	return failed
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the connection to Ollama, the configured models and the database",
+	Long: `Check the configuration, whether Ollama answers at the configured endpoint,
+whether the chat and embedding models are pulled and whether the database is
+healthy. Exits with status 1 when a check fails.`,
+	Args: cobra.NoArgs,
+	// The database is checked like the rest instead of failing up front
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		dbErr := db.InitDB()
+
+		checks := runDoctor(getOllamaClient(), getSupervisor(), dbErr)
+		if printChecks(os.Stdout, checks) {
+			fmt.Fprintln(os.Stderr, "Some checks failed.")
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// editorFinishedMsg carries the text written in the external editor.
+type editorFinishedMsg struct {
+	content string
+	// saved is false when the editor quit without writing the file
+	saved bool
+	err   error
+}
+
+// editorCommand runs $VISUAL or $EDITOR, falling back to vi, on path.
+func editorCommand(path string) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may come with arguments, as in "code --wait"
+	fields := strings.Fields(editor)
+	return exec.Command(fields[0], append(fields[1:], path)...)
+}
+
+// openEditor suspends the TUI and edits content in a temp file.
+func openEditor(content string) (tea.Cmd, error) {
+	file, err := os.CreateTemp("", "termpilot-*.md")
+	if err != nil {
+		return nil, err
+	}
+	path := file.Name()
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	// Backdate the file so saving it unchanged still moves its modification
+	// time, whatever the resolution of the filesystem clock
+	written := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, written, written); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return tea.ExecProcess(editorCommand(path), func(err error) tea.Msg {
+		defer os.Remove(path)
+		if err != nil {
+			return editorFinishedMsg{err: err}
+		}
+		return readEdited(path, written)
+	}), nil
+}
+
+// readEdited reads the file at path after the editor exits; it counts as
+// saved when it was written after the written time, even if unchanged.
+func readEdited(path string, written time.Time) editorFinishedMsg {
+	info, err := os.Stat(path)
+	if err != nil {
+		return editorFinishedMsg{err: err}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return editorFinishedMsg{err: err}
+	}
+	edited := strings.TrimRight(string(data), "\n")
+	return editorFinishedMsg{content: edited, saved: !info.ModTime().Equal(written)}
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"termpilot/rag"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	embedCmd.Flags().String("format", "json", "output format (json or binary)")
+	embedCmd.Flags().StringP("output", "o", "", "write the embeddings to a file instead of stdout")
+
+	rootCmd.AddCommand(embedCmd)
+}
+
+type embedOutput struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// readEmbedInputs returns args when given, otherwise one input per non-empty
+// line of r.
+func readEmbedInputs(args []string, r io.Reader) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+
+	var inputs []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			inputs = append(inputs, line)
+		}
+	}
+	return inputs, scanner.Err()
+}
+
+// writeEmbeddings writes embeddings as JSON, or in the binary format: a
+// little-endian uint32 count and uint32 dimension followed by count*dimension
+// little-endian float32 values.
+func writeEmbeddings(w io.Writer, format string, model string, embeddings [][]float32) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(embedOutput{Model: model, Embeddings: embeddings})
+	case "binary":
+		dimension := 0
+		if len(embeddings) > 0 {
+			dimension = len(embeddings[0])
+		}
+		header := []uint32{uint32(len(embeddings)), uint32(dimension)}
+		if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+			return err
+		}
+		for _, embedding := range embeddings {
+			if len(embedding) != dimension {
+				return fmt.Errorf("embeddings have mismatched dimensions %d and %d", dimension, len(embedding))
+			}
+			if _, err := w.Write(rag.EncodeVector(embedding)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+var embedCmd = &cobra.Command{
+	Use:   "embed [text...]",
+	Short: "Compute embeddings for the given texts or for each line of stdin",
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			log.Fatalf("Failed to get format: %v", err)
+		}
+
+		outputPath, err := cmd.Flags().GetString("output")
+		if err != nil {
+			log.Fatalf("Failed to get output: %v", err)
+		}
+
+		inputs, err := readEmbedInputs(args, os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read input: %v", err)
+		}
+
+		if len(inputs) == 0 {
+			log.Fatalf("Nothing to embed")
+		}
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		ollamaClient := getOllamaClient()
+		embeddings, err := ollamaClient.Embed(cmd.Context(), inputs)
+		if err != nil {
+			log.Fatalf("Failed to compute embeddings: %v", err)
+		}
+
+		var out io.Writer = os.Stdout
+		if outputPath != "" {
+			file, err := os.Create(outputPath)
+			if err != nil {
+				log.Fatalf("Failed to create output file: %v", err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		if err := writeEmbeddings(out, format, ollamaClient.EmbedModel, embeddings); err != nil {
+			log.Fatalf("Failed to write embeddings: %v", err)
+		}
+	},
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"termpilot/db"
+	"termpilot/models"
+	"termpilot/ollamaclient"
+	"termpilot/shell"
+
+	"github.com/spf13/cobra"
+)
+
+const explainTagPrefix = "explain:"
+
+func init() {
+	explainCmd.Flags().String("command", "", "command to explain instead of the last one from the shell integration")
+	explainCmd.Flags().Int("exit-code", 0, "exit code of --command")
+	explainCmd.Flags().Bool("rerun", false, "run the command again to capture its output")
+	explainCmd.Flags().Bool("list", false, "list previous explanations")
+
+	rootCmd.AddCommand(explainCmd)
+}
+
+type failedCommand struct {
+	Command  string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// lastCommandFromEnv reads the variables exported by the shell-init snippets.
+func lastCommandFromEnv() (*failedCommand, error) {
+	command := strings.TrimSpace(os.Getenv("TERMPILOT_LAST_COMMAND"))
+	if command == "" {
+		return nil, fmt.Errorf("no last command found, set up the shell integration with `termpilot shell-init` or pass --command")
+	}
+	if strings.HasPrefix(command, "termpilot explain") {
+		return nil, fmt.Errorf("the last command was termpilot explain itself")
+	}
+
+	exitCode, err := strconv.Atoi(strings.TrimSpace(os.Getenv("TERMPILOT_LAST_STATUS")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TERMPILOT_LAST_STATUS: %v", err)
+	}
+	return &failedCommand{Command: command, ExitCode: exitCode}, nil
+}
+
+func explainPrompt(failed *failedCommand) string {
+	var b strings.Builder
+	if failed.ExitCode == 0 {
+		fmt.Fprintf(&b, "I ran this shell command (%s on %s) and it succeeded:\n\n", shell.Shell(), runtime.GOOS)
+	} else {
+		fmt.Fprintf(&b, "I ran this shell command (%s on %s) and it failed with exit status %d:\n\n", shell.Shell(), runtime.GOOS, failed.ExitCode)
+	}
+	fmt.Fprintf(&b, "```\n%s\n```\n\n", failed.Command)
+	if failed.Stdout != "" {
+		fmt.Fprintf(&b, "stdout:\n```\n%s\n```\n\n", truncateTail(failed.Stdout))
+	}
+	if failed.Stderr != "" {
+		fmt.Fprintf(&b, "stderr:\n```\n%s\n```\n\n", truncateTail(failed.Stderr))
+	}
+	if failed.ExitCode == 0 {
+		b.WriteString("Briefly explain what the command did.")
+	} else {
+		b.WriteString("Briefly explain the most likely cause of the failure and how to fix it, including a corrected command if one applies.")
+	}
+	return b.String()
+}
+
+func listExplanations() {
+	conversations, err := db.GetConversationsByTagPrefix(explainTagPrefix)
+	if err != nil {
+		log.Fatalf("Failed to list explanations: %v", err)
+	}
+	fmt.Println("Explanations (", len(conversations), "):")
+	for _, conversation := range conversations {
+		fmt.Println(conversation.ID, strings.TrimPrefix(conversation.Tag, explainTagPrefix))
+	}
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Explain why the last shell command failed",
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := cmd.Flags().GetBool("list")
+		if err != nil {
+			log.Fatalf("Failed to get list: %v", err)
+		}
+
+		if list {
+			listExplanations()
+			return
+		}
+
+		command, err := cmd.Flags().GetString("command")
+		if err != nil {
+			log.Fatalf("Failed to get command: %v", err)
+		}
+
+		var failed *failedCommand
+		if command != "" {
+			exitCode, err := cmd.Flags().GetInt("exit-code")
+			if err != nil {
+				log.Fatalf("Failed to get exit-code: %v", err)
+			}
+			failed = &failedCommand{Command: command, ExitCode: exitCode}
+		} else if failed, err = lastCommandFromEnv(); err != nil {
+			log.Fatalf("Failed to get last command: %v", err)
+		}
+
+		rerun, err := cmd.Flags().GetBool("rerun")
+		if err != nil {
+			log.Fatalf("Failed to get rerun: %v", err)
+		}
+
+		if rerun {
+			if risk, reasons := shell.Classify(failed.Command); risk != shell.RiskLow {
+				log.Fatalf("Not re-running a %s risk command (%s)", risk, strings.Join(reasons, ", "))
+			}
+			result, err := shell.Run(cmd.Context(), failed.Command, nil, nil)
+			if err != nil {
+				log.Fatalf("Failed to re-run command: %v", err)
+			}
+			failed.ExitCode, failed.Stdout, failed.Stderr = result.ExitCode, result.Stdout, result.Stderr
+		}
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		prompt := explainPrompt(failed)
+		response, err := getOllamaClient().ChatCompletion(prompt, []ollamaclient.Message{})
+		if err != nil {
+			log.Fatalf("Failed to get response: %v", err)
+		}
+
+		title := "explain: " + failed.Command
+		db.CreateConversation(models.Conversation{
+			ID:       newConversationID(),
+			Title:    firstRunes(title, 20),
+			Tag:      explainTagPrefix + failed.Command,
+			Messages: []models.Message{{Content: prompt, Role: "user"}, {Content: response, Role: "assistant"}},
+		})
+
+		fmt.Print(fancyPrint(response))
+	},
+}
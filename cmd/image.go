@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"termpilot/models"
+	"termpilot/ollamaclient"
+)
+
+// loadImage reads the image at path to attach it to a prompt.
+func loadImage(path string) (models.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return models.Image{}, err
+	}
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return models.Image{}, fmt.Errorf("%s is not an image (%s)", path, mimeType)
+	}
+	return models.Image{
+		Filename: filepath.Base(path),
+		MimeType: mimeType,
+		Hash:     fmt.Sprintf("%x", sha256.Sum256(data)),
+		Data:     data,
+	}, nil
+}
+
+func loadImages(paths []string) ([]models.Image, error) {
+	images := make([]models.Image, 0, len(paths))
+	for _, path := range paths {
+		image, err := loadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func toClientImages(images []models.Image) []ollamaclient.Image {
+	if len(images) == 0 {
+		return nil
+	}
+	clientImages := make([]ollamaclient.Image, len(images))
+	for i, image := range images {
+		clientImages[i] = ollamaclient.Image{MimeType: image.MimeType, Data: image.Data}
+	}
+	return clientImages
+}
+
+// describeImages renders markers for the images attached to a message.
+func describeImages(message models.Message) string {
+	var b strings.Builder
+	for _, image := range message.Images {
+		fmt.Fprintf(&b, "*[image: %s, %s]*\n\n", image.Filename, formatSize(int64(len(image.Data))))
+	}
+	return b.String()
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"termpilot/db"
+	"termpilot/models"
+	"termpilot/ollamaclient"
+	"termpilot/rag"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	indexCmd.Flags().String("name", "", "name of the index (default is the directory name)")
+	indexCmd.Flags().Int("chunk-lines", rag.DefaultChunkLines, "number of lines per chunk")
+	indexCmd.Flags().Int("chunk-overlap", rag.DefaultChunkOverlap, "number of lines shared by consecutive chunks")
+	indexCmd.Flags().Bool("list", false, "list all indexes")
+	indexCmd.Flags().String("delete", "", "delete an index")
+
+	rootCmd.AddCommand(indexCmd)
+}
+
+func listIndexes() {
+	indexes, err := db.GetAllIndexes()
+	if err != nil {
+		log.Fatalf("Failed to list indexes: %v", err)
+	}
+	fmt.Println("Indexes (", len(indexes), "):")
+	for _, index := range indexes {
+		fmt.Println(index.Name, index.Root, index.Model)
+	}
+}
+
+func buildIndex(ctx context.Context, name string, root string, chunkLines int, chunkOverlap int, ollamaClient *ollamaclient.OllamaClient) (*models.Index, error) {
+	files, err := rag.CollectFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []models.Chunk
+	for _, file := range files {
+		fileChunks, err := rag.ChunkFile(root, file, chunkLines, chunkOverlap)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, fileChunks...)
+	}
+
+	// Embed sends the inputs in batches of EmbedBatchSize
+	inputs := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		inputs = append(inputs, chunk.Source+"\n"+chunk.Content)
+	}
+	embeddings, err := ollamaClient.Embed(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+	for i, embedding := range embeddings {
+		chunks[i].Embedding = rag.EncodeVector(embedding)
+	}
+	fmt.Printf("Embedded %d chunks\n", len(chunks))
+
+	return db.SaveIndex(models.Index{
+		Name:   name,
+		Root:   root,
+		Model:  ollamaClient.EmbedModel,
+		Chunks: chunks,
+	})
+}
+
+// retrieveContext augments prompt with the chunks of the named index that are
+// most relevant to it.
+func retrieveContext(indexName string, prompt string, topK int, ollamaClient *ollamaclient.OllamaClient) (string, error) {
+	index, err := db.GetIndex(indexName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get index %q: %v", indexName, err)
+	}
+
+	// Queries must be embedded with the model the index was built with
+	embedClient := *ollamaClient
+	embedClient.EmbedModel = index.Model
+
+	embeddings, err := embedClient.Embed(context.Background(), []string{prompt})
+	if err != nil {
+		return "", err
+	}
+
+	results := rag.Search(embeddings[0], index.Chunks, topK)
+	return rag.BuildPrompt(prompt, results), nil
+}
+
+var indexCmd = &cobra.Command{
+	Use:   "index [path]",
+	Short: "Index a directory for retrieval augmented chat",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := cmd.Flags().GetBool("list")
+		if err != nil {
+			log.Fatalf("Failed to get list: %v", err)
+		}
+
+		if list {
+			listIndexes()
+			return
+		}
+
+		deleteName, err := cmd.Flags().GetString("delete")
+		if err != nil {
+			log.Fatalf("Failed to get delete: %v", err)
+		}
+
+		if deleteName != "" {
+			if err := db.DeleteIndex(deleteName); err != nil {
+				log.Fatalf("Failed to delete index: %v", err)
+			}
+			fmt.Println("Deleted index", deleteName)
+			return
+		}
+
+		if len(args) == 0 {
+			log.Fatalf("A path to index is required")
+		}
+
+		root, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatalf("Failed to resolve path: %v", err)
+		}
+
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			log.Fatalf("Failed to get name: %v", err)
+		}
+		if name == "" {
+			name = filepath.Base(root)
+		}
+
+		chunkLines, err := cmd.Flags().GetInt("chunk-lines")
+		if err != nil {
+			log.Fatalf("Failed to get chunk-lines: %v", err)
+		}
+
+		chunkOverlap, err := cmd.Flags().GetInt("chunk-overlap")
+		if err != nil {
+			log.Fatalf("Failed to get chunk-overlap: %v", err)
+		}
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		index, err := buildIndex(cmd.Context(), name, root, chunkLines, chunkOverlap, getOllamaClient())
+		if err != nil {
+			log.Fatalf("Failed to build index: %v", err)
+		}
+
+		fmt.Printf("Indexed %d chunks from %s as %q\n", len(index.Chunks), root, index.Name)
+	},
+}
+
+type ragOptions struct {
+	index string
+	topK  int
+}
+
+func (r ragOptions) enabled() bool {
+	return r.index != ""
+}
+
+func (r ragOptions) augment(prompt string, ollamaClient *ollamaclient.OllamaClient) (string, error) {
+	if !r.enabled() {
+		return prompt, nil
+	}
+	return retrieveContext(r.index, prompt, r.topK, ollamaClient)
+}
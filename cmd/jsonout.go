@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"termpilot/jsonschema"
+	"termpilot/models"
+	"termpilot/ollamaclient"
+)
+
+const defaultJSONRetries = 2
+
+// trimCodeFence strips the markdown code fence models sometimes put around
+// JSON replies.
+func trimCodeFence(reply string) string {
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimPrefix(reply, "```json")
+	reply = strings.TrimPrefix(reply, "```")
+	reply = strings.TrimSuffix(reply, "```")
+	return strings.TrimSpace(reply)
+}
+
+func jsonSystemPrompt(system string, schema json.RawMessage) string {
+	prompt := "Reply only with JSON matching this JSON Schema, without any other text:\n" + string(schema)
+	if system != "" {
+		prompt = system + "\n\n" + prompt
+	}
+	return prompt
+}
+
+// completeJSON asks for a reply to prompt matching schema. Replies that do
+// not validate are sent back with the errors, up to retries more times. It
+// returns the validated JSON.
+func completeJSON(prompt string, history []models.Message, opts chatOptions, ollamaClient *ollamaclient.OllamaClient, raw json.RawMessage, retries int) (string, error) {
+	schema, err := jsonschema.Compile(raw)
+	if err != nil {
+		return "", err
+	}
+
+	augmented, err := opts.rag.augment(prompt, ollamaClient)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve context: %v", err)
+	}
+
+	messages := append([]ollamaclient.Message{{Role: "system", Content: jsonSystemPrompt(opts.system, raw)}}, toClientMessages(history)...)
+	messages = append(messages, ollamaclient.Message{
+		Role:    "user",
+		Content: attachmentText(opts.attachments) + augmented,
+		Images:  toClientImages(opts.images),
+	})
+
+	for attempt := 0; ; attempt++ {
+		reply, err := ollamaClient.ChatCompletionJSON(messages, raw)
+		if err != nil {
+			return "", err
+		}
+		reply = trimCodeFence(reply)
+
+		err = schema.Validate([]byte(reply))
+		if err == nil {
+			return reply, nil
+		}
+		if attempt == retries {
+			return "", fmt.Errorf("reply does not match the schema after %d attempts: %v", attempt+1, err)
+		}
+
+		messages = append(messages,
+			ollamaclient.Message{Role: "assistant", Content: reply},
+			ollamaclient.Message{Role: "user", Content: fmt.Sprintf("Your reply does not match the schema: %v\nReply again with only the corrected JSON.", err)},
+		)
+	}
+}
+
+// answerJSON prints only the validated JSON reply to the prompt in args and
+// saves the exchange with conversation.
+func answerJSON(conversation *models.Conversation, args []string, opts chatOptions, ollamaClient *ollamaclient.OllamaClient, schemaPath string, retries int) {
+	raw, err := os.ReadFile(schemaPath)
+	if err != nil {
+		log.Fatalf("Failed to read schema: %v", err)
+	}
+
+	if opts.system == "" {
+		opts.system = conversation.SystemPrompt
+	}
+
+	prompt := strings.Join(args, " ")
+	reply, err := completeJSON(prompt, conversation.Messages, opts, ollamaClient, raw, retries)
+	if err != nil {
+		log.Fatalf("Failed to get response: %v", err)
+	}
+
+	if conversation.Title == "" {
+		conversation.Title = firstRunes(prompt, 20)
+	}
+	conversation.Messages = append(conversation.Messages,
+		models.Message{Content: prompt, Role: "user", Images: opts.images, Attachments: opts.attachments},
+		models.Message{Content: reply, Role: "assistant"},
+	)
+	if err := saveConversation(conversation); err != nil {
+		log.Fatalf("Failed to save conversation: %v", err)
+	}
+
+	fmt.Println(reply)
+}
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/spf13/viper"
+)
+
+// keyMap holds the key bindings of the TUI.
+type keyMap struct {
+	Quit          key.Binding
+	Help          key.Binding
+	SwitchFocus   key.Binding
+	ToggleSidebar key.Binding
+	NewChat       key.Binding
+
+	// Sidebar, where single letters are free to use
+	Open      key.Binding
+	QuickNew  key.Binding
+	QuickQuit key.Binding
+
+	// Chat
+	Send       key.Binding
+	Newline    key.Binding
+	Complete   key.Binding
+	Editor     key.Binding
+	Select     key.Binding
+	ToggleRag  key.Binding
+	Models     key.Binding
+	Parameters key.Binding
+	Templates  key.Binding
+	Attach     key.Binding
+	Retry      key.Binding
+	Back       key.Binding
+
+	// Select mode, also moving through the sidebar
+	Up        key.Binding
+	Down      key.Binding
+	NextBlock key.Binding
+	Copy      key.Binding
+	Write     key.Binding
+}
+
+func newBinding(description string, keys ...string) key.Binding {
+	return key.NewBinding(key.WithKeys(keys...), key.WithHelp(keys[0], description))
+}
+
+// rebind replaces the keys of a binding, keeping its description.
+func rebind(binding *key.Binding, keys ...string) {
+	*binding = newBinding(binding.Help().Desc, keys...)
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		Quit:          newBinding("quit", "ctrl+c"),
+		Help:          newBinding("more keys", "?"),
+		SwitchFocus:   newBinding("switch pane", "tab", "shift+tab"),
+		ToggleSidebar: newBinding("toggle sidebar", "ctrl+s"),
+		NewChat:       newBinding("new chat", "ctrl+n"),
+
+		Open:      newBinding("open", "enter"),
+		QuickNew:  newBinding("new chat", "n"),
+		QuickQuit: newBinding("quit", "q"),
+
+		Send:       newBinding("send", "enter"),
+		Newline:    newBinding("newline", "alt+enter", "shift+enter", "ctrl+j"),
+		Complete:   newBinding("complete /command", "tab"),
+		Editor:     newBinding("$EDITOR", "ctrl+g"),
+		Select:     newBinding("select to copy", "ctrl+y"),
+		ToggleRag:  newBinding("toggle RAG", "ctrl+r"),
+		Models:     newBinding("models", "ctrl+o"),
+		Parameters: newBinding("parameters", "ctrl+p"),
+		Templates:  newBinding("templates", "ctrl+t"),
+		Attach:     newBinding("attach image", "alt+a"),
+		Retry:      newBinding("send pending", "alt+r"),
+		Back:       newBinding("back", "esc"),
+
+		Up:        newBinding("up", "up", "k"),
+		Down:      newBinding("down", "down", "j"),
+		NextBlock: newBinding("code block", "tab"),
+		Copy:      newBinding("copy", "y", "c"),
+		Write:     newBinding("write to file", "w"),
+	}
+}
+
+// keyPresets adjust the default key map to the habits of an editor.
+var keyPresets = map[string]func(k *keyMap){
+	"default": func(k *keyMap) {},
+	"vim": func(k *keyMap) {
+		rebind(&k.SwitchFocus, "tab", "shift+tab", "ctrl+w")
+		rebind(&k.Open, "enter", "l")
+		rebind(&k.QuickNew, "o", "n")
+		rebind(&k.Back, "esc", "ctrl+[")
+		rebind(&k.NextBlock, "tab", "n")
+	},
+	"emacs": func(k *keyMap) {
+		rebind(&k.NewChat, "alt+n")
+		rebind(&k.SwitchFocus, "tab", "shift+tab", "alt+o")
+		rebind(&k.Editor, "alt+e")
+		rebind(&k.Back, "esc", "ctrl+g")
+		rebind(&k.Up, "up", "ctrl+p")
+		rebind(&k.Down, "down", "ctrl+n")
+		rebind(&k.Copy, "alt+w", "y")
+	},
+}
+
+// actions maps the names used in the keys config section to the bindings.
+func (k *keyMap) actions() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"quit":           &k.Quit,
+		"help":           &k.Help,
+		"switch_focus":   &k.SwitchFocus,
+		"toggle_sidebar": &k.ToggleSidebar,
+		"new_chat":       &k.NewChat,
+		"open":           &k.Open,
+		"quick_new":      &k.QuickNew,
+		"quick_quit":     &k.QuickQuit,
+		"send":           &k.Send,
+		"newline":        &k.Newline,
+		"complete":       &k.Complete,
+		"editor":         &k.Editor,
+		"select":         &k.Select,
+		"toggle_rag":     &k.ToggleRag,
+		"models":         &k.Models,
+		"parameters":     &k.Parameters,
+		"templates":      &k.Templates,
+		"attach":         &k.Attach,
+		"retry":          &k.Retry,
+		"back":           &k.Back,
+		"up":             &k.Up,
+		"down":           &k.Down,
+		"next_block":     &k.NextBlock,
+		"copy":           &k.Copy,
+		"write":          &k.Write,
+	}
+}
+
+// keyContexts lists the actions active at the same time, which must not
+// share keys. Complete is left out on purpose: it only applies while the
+// input holds a /command and otherwise lets the key through.
+var keyContexts = map[string][]string{
+	"sidebar": {"quit", "help", "switch_focus", "toggle_sidebar", "new_chat", "open", "quick_new", "quick_quit", "up", "down"},
+	"chat":    {"quit", "switch_focus", "toggle_sidebar", "new_chat", "send", "newline", "editor", "select", "toggle_rag", "models", "parameters", "templates", "attach", "retry", "back"},
+	"select":  {"quit", "select", "back", "up", "down", "next_block", "copy", "write"},
+}
+
+// loadKeyMap builds the key map from the keymap preset and the keys section
+// of the config, then checks it for conflicts.
+func loadKeyMap() (keyMap, error) {
+	keys := defaultKeyMap()
+
+	preset := viper.GetString("keymap")
+	if preset != "" {
+		apply, ok := keyPresets[preset]
+		if !ok {
+			return keys, fmt.Errorf("unknown keymap %q, expected default, vim or emacs", preset)
+		}
+		apply(&keys)
+	}
+
+	actions := keys.actions()
+	for name, bound := range viper.GetStringMapStringSlice("keys") {
+		binding, ok := actions[name]
+		if !ok {
+			return keys, fmt.Errorf("unknown key action %q", name)
+		}
+		if len(bound) == 0 {
+			return keys, fmt.Errorf("no keys given for %q", name)
+		}
+		rebind(binding, bound...)
+	}
+
+	return keys, keys.validate()
+}
+
+// validate reports keys bound to several actions of the same context.
+func (k *keyMap) validate() error {
+	actions := k.actions()
+
+	var conflicts []string
+	for _, context := range []string{"sidebar", "chat", "select"} {
+		owners := map[string]string{}
+		for _, name := range keyContexts[context] {
+			for _, bound := range actions[name].Keys() {
+				if owner, ok := owners[bound]; ok {
+					conflicts = append(conflicts, fmt.Sprintf("%s is bound to both %s and %s in the %s", bound, owner, name, context))
+					continue
+				}
+				owners[bound] = name
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("conflicting key bindings: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// helpKeys adapts a set of bindings to help.KeyMap.
+type helpKeys struct {
+	short []key.Binding
+	full  [][]key.Binding
+}
+
+func (h helpKeys) ShortHelp() []key.Binding  { return h.short }
+func (h helpKeys) FullHelp() [][]key.Binding { return h.full }
+
+func (k keyMap) sidebarHelp() helpKeys {
+	return helpKeys{
+		short: []key.Binding{k.Open, k.QuickNew, k.SwitchFocus, k.Help, k.Quit},
+		full: [][]key.Binding{
+			{k.Open, k.QuickNew, k.NewChat},
+			{k.Up, k.Down},
+			{k.SwitchFocus, k.ToggleSidebar},
+			{k.Help, k.QuickQuit, k.Quit},
+		},
+	}
+}
+
+func (k keyMap) chatHelp() helpKeys {
+	return helpKeys{
+		short: []key.Binding{k.Send, k.Newline, k.Editor, k.Select, k.Back, k.Quit},
+		full: [][]key.Binding{
+			{k.Send, k.Newline, k.Complete},
+			{k.Editor, k.Select, k.Attach, k.Retry, k.ToggleRag},
+			{k.Models, k.Parameters, k.Templates},
+			{k.NewChat, k.ToggleSidebar, k.Back, k.Quit},
+		},
+	}
+}
+
+func (k keyMap) selectHelp() helpKeys {
+	return helpKeys{
+		short: []key.Binding{k.Up, k.Down, k.NextBlock, k.Copy, k.Write, k.Back},
+		full:  [][]key.Binding{{k.Up, k.Down, k.NextBlock}, {k.Copy, k.Write, k.Back}},
+	}
+}
+
+func (k keyMap) pickerHelp() helpKeys {
+	filter := newBinding("filter", "/")
+	return helpKeys{
+		short: []key.Binding{k.Open, filter, k.Back},
+		full:  [][]key.Binding{{k.Open, filter, k.Back}},
+	}
+}
+
+func (k keyMap) compareHelp() helpKeys {
+	pick := newBinding("keep reply", "1-9")
+	skip := newBinding("keep none", append([]string{"0"}, k.Back.Keys()...)...)
+	return helpKeys{
+		short: []key.Binding{pick, skip, k.Up, k.Down},
+		full:  [][]key.Binding{{pick, skip}, {k.Up, k.Down}},
+	}
+}
+
+func (k keyMap) templateVarsHelp() helpKeys {
+	next := newBinding("next field", "tab")
+	use := newBinding("use template", k.Send.Keys()...)
+	return helpKeys{
+		short: []key.Binding{next, use, k.Back},
+		full:  [][]key.Binding{{next, use, k.Back}},
+	}
+}
+
+func (k keyMap) paramsHelp() helpKeys {
+	next := newBinding("next field", "tab")
+	save := newBinding("save", k.Send.Keys()...)
+	return helpKeys{
+		short: []key.Binding{next, save, k.Back},
+		full:  [][]key.Binding{{next, save, k.Back}},
+	}
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"termpilot/ollamaclient"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// startedOllama is the server this run started, if any, for stopOllama.
+var startedOllama *ollamaclient.Supervisor
+
+func init() {
+	ollamaLogsCmd.Flags().IntP("lines", "n", 20, "number of lines to show")
+	ollamaLogsCmd.Flags().BoolP("follow", "f", false, "keep printing lines as they are written")
+
+	ollamaCmd.AddCommand(ollamaStartCmd, ollamaStopCmd, ollamaStatusCmd, ollamaLogsCmd)
+	rootCmd.AddCommand(ollamaCmd)
+}
+
+// ollamaDir holds the logs and PID files of the servers termpilot starts,
+// the ollama-dir config value or termpilot in the user cache directory.
+func ollamaDir() string {
+	if dir := currentConfig().OllamaDir; dir != "" {
+		return dir
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(cache, "termpilot")
+}
+
+// getSupervisor returns the supervisor of the Ollama server at the
+// configured endpoint.
+func getSupervisor() *ollamaclient.Supervisor {
+	cfg := currentConfig()
+	return ollamaclient.NewSupervisor(cfg.BaseURL, cfg.Port, ollamaDir())
+}
+
+// startOllama starts Ollama when it is not running, as the auto_start config
+// value says. Asking only happens in a terminal, so piped runs never wait
+// for an answer.
+func startOllama() error {
+	var ask func() bool
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd())) {
+		ask = func() bool { return ollamaclient.AskToStartOllama(os.Stdin, os.Stderr) }
+	}
+
+	supervisor := getSupervisor()
+	err := supervisor.StartIfNotRunning(currentConfig().AutoStart, ask)
+	if supervisor.Started() {
+		startedOllama = supervisor
+	}
+	return err
+}
+
+// stopOllama stops the server this run started if the stop-ollama config
+// value asks for it.
+func stopOllama() {
+	if startedOllama == nil || !currentConfig().StopOllama {
+		return
+	}
+	if err := startedOllama.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stop ollama: %v\n", err)
+	}
+}
+
+// tailLines returns the last n lines of data.
+func tailLines(data []byte, n int) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return bytes.Join(lines[max(len(lines)-n, 0):], nil)
+}
+
+var ollamaCmd = &cobra.Command{
+	Use:   "ollama",
+	Short: "Start, stop and inspect the Ollama server at the configured endpoint",
+	Long: `Start, stop and inspect the Ollama server at the configured base-url and
+port. Servers started by termpilot keep running after it exits, writing their
+logs and PID to the ollama-dir config value (termpilot in the user cache
+directory by default). With the stop-ollama config value or flag, a server
+started on demand by another command is stopped when that command ends.`,
+}
+
+var ollamaStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the Ollama server",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		supervisor := getSupervisor()
+		if supervisor.Running() {
+			fmt.Printf("Ollama is already running at %s\n", supervisor.Endpoint())
+			return
+		}
+		if err := supervisor.Start(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		pid, _ := supervisor.PID()
+		fmt.Printf("Started Ollama at %s (PID %d), logging to %s\n", supervisor.Endpoint(), pid, supervisor.LogPath())
+	},
+}
+
+var ollamaStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the Ollama server started by termpilot",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		supervisor := getSupervisor()
+		if err := supervisor.Stop(); err != nil {
+			log.Fatalf("Failed to stop ollama: %v", err)
+		}
+		fmt.Printf("Stopped Ollama at %s\n", supervisor.Endpoint())
+	},
+}
+
+var ollamaStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the Ollama server is running and who started it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		supervisor := getSupervisor()
+		pid, err := supervisor.PID()
+		if err != nil {
+			log.Fatalf("Failed to read the PID file: %v", err)
+		}
+
+		if supervisor.Running() {
+			fmt.Printf("Ollama is running at %s\n", supervisor.Endpoint())
+		} else {
+			fmt.Printf("Ollama is not running at %s\n", supervisor.Endpoint())
+		}
+		if pid != 0 {
+			fmt.Printf("Started by termpilot, PID %d\n", pid)
+		}
+		if _, err := os.Stat(supervisor.LogPath()); err == nil {
+			fmt.Printf("Logs: %s\n", supervisor.LogPath())
+		}
+	},
+}
+
+var ollamaLogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show the logs of the Ollama server started by termpilot",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		lines, err := cmd.Flags().GetInt("lines")
+		if err != nil {
+			log.Fatalf("Failed to get lines: %v", err)
+		}
+
+		follow, err := cmd.Flags().GetBool("follow")
+		if err != nil {
+			log.Fatalf("Failed to get follow: %v", err)
+		}
+
+		file, err := os.Open(getSupervisor().LogPath())
+		if err != nil {
+			log.Fatalf("Failed to open logs: %v", err)
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			log.Fatalf("Failed to read logs: %v", err)
+		}
+		os.Stdout.Write(tailLines(data, lines))
+		if !follow {
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		for {
+			if _, err := io.Copy(os.Stdout, file); err != nil && !errors.Is(err, io.EOF) {
+				log.Fatalf("Failed to read logs: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	},
+}
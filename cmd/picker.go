@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"termpilot/ollamaclient"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type modalKind int
+
+const (
+	modalNone modalKind = iota
+	modalModels
+	modalParams
+	modalCompare
+	modalTemplates
+	modalTemplateVars
+)
+
+type modelItem struct {
+	ollamaclient.ModelInfo
+}
+
+func (i modelItem) Title() string { return i.Name }
+func (i modelItem) Description() string {
+	if i.Loaded {
+		return formatSize(i.Size) + " · loaded"
+	}
+	return formatSize(i.Size)
+}
+func (i modelItem) FilterValue() string { return i.Name }
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.0f MB", float64(size)/(1<<20))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+type modelsLoadedMsg struct {
+	models []ollamaclient.ModelInfo
+	err    error
+}
+
+func newModelPicker() list.Model {
+	l := list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	l.Title = "Models"
+	l.SetShowHelp(false)
+	l.KeyMap.Quit.SetEnabled(false)
+	l.KeyMap.ForceQuit.SetEnabled(false)
+	return l
+}
+
+// openModelPicker shows the picker and loads the models in the background.
+func openModelPicker(m model) (model, tea.Cmd) {
+	m.modal = modalModels
+	m.modelPicker.ResetFilter()
+	m.modelPicker.SetItems(nil)
+	m.modelPicker.Title = "Models (loading...)"
+
+	client := *m.session.client
+	return layout(m), func() tea.Msg {
+		models, err := client.ListModelDetails()
+		return modelsLoadedMsg{models: models, err: err}
+	}
+}
+
+func modelsLoaded(m model, msg modelsLoadedMsg) model {
+	if m.modal != modalModels {
+		return m
+	}
+	if msg.err != nil {
+		m.modal = modalNone
+		m.status = "Failed to list models: " + msg.err.Error()
+		return layout(m)
+	}
+
+	items := make([]list.Item, len(msg.models))
+	current := 0
+	for i, info := range msg.models {
+		items[i] = modelItem{info}
+		if info.Name == m.session.client.Model {
+			current = i
+		}
+	}
+	m.modelPicker.Title = "Models"
+	m.modelPicker.SetItems(items)
+	m.modelPicker.Select(current)
+	return m
+}
+
+func updateModelPicker(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Keys go to the filter while one is typed
+	if m.modelPicker.FilterState() != list.Filtering {
+		switch {
+		case key.Matches(msg, m.keys.Back):
+			m.modal = modalNone
+			return layout(m), nil
+
+		case key.Matches(msg, m.keys.Open):
+			selected, ok := m.modelPicker.SelectedItem().(modelItem)
+			if !ok {
+				return m, nil
+			}
+			m.session.client.Model = selected.Name
+			if err := m.session.saveSettings(); err != nil {
+				m.status = "Error: " + err.Error()
+			} else {
+				m.status = "Model: " + selected.Name
+			}
+			m.modal = modalNone
+			return layout(m), nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.modelPicker, cmd = m.modelPicker.Update(msg)
+	return m, cmd
+}
+
+// paramFields are the options edited in the parameter panel, in order.
+var paramFields = []string{"temperature", "top_p", "num_ctx", "seed"}
+
+func formatFloat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'g', -1, 64)
+}
+
+func formatInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+func newParamInputs(options ollamaclient.Options) []textinput.Model {
+	values := []string{
+		formatFloat(options.Temperature),
+		formatFloat(options.TopP),
+		formatInt(options.NumCtx),
+		formatInt(options.Seed),
+	}
+
+	inputs := make([]textinput.Model, len(paramFields))
+	for i, name := range paramFields {
+		inputs[i] = textinput.New()
+		inputs[i].Prompt = fmt.Sprintf("%-12s ", name)
+		inputs[i].Placeholder = "model default"
+		inputs[i].SetValue(values[i])
+	}
+	inputs[0].Focus()
+	return inputs
+}
+
+// parseParams turns the values of the parameter panel into options, leaving
+// empty values unset.
+func parseParams(values []string) (ollamaclient.Options, error) {
+	var options ollamaclient.Options
+
+	parseFloat := func(name, value string, max float64) (*float64, error) {
+		if value == "" {
+			return nil, nil
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > max {
+			return nil, fmt.Errorf("%s must be a number between 0 and %g", name, max)
+		}
+		return &parsed, nil
+	}
+	parseInt := func(name, value string, min int) (*int, error) {
+		if value == "" {
+			return nil, nil
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < min {
+			return nil, fmt.Errorf("%s must be a whole number of at least %d", name, min)
+		}
+		return &parsed, nil
+	}
+
+	var err error
+	if options.Temperature, err = parseFloat("temperature", values[0], 2); err != nil {
+		return options, err
+	}
+	if options.TopP, err = parseFloat("top_p", values[1], 1); err != nil {
+		return options, err
+	}
+	if options.NumCtx, err = parseInt("num_ctx", values[2], 1); err != nil {
+		return options, err
+	}
+	if options.Seed, err = parseInt("seed", values[3], 0); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+func openParams(m model) model {
+	m.modal = modalParams
+	m.params = newParamInputs(m.session.client.Options)
+	m.paramFocus = 0
+	return layout(m)
+}
+
+func focusParam(m model, index int) model {
+	m.params[m.paramFocus].Blur()
+	m.paramFocus = (index + len(m.params)) % len(m.params)
+	m.params[m.paramFocus].Focus()
+	return m
+}
+
+func updateParams(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Back):
+		m.modal = modalNone
+		return layout(m), nil
+
+	case key.Matches(msg, m.keys.Send):
+		values := make([]string, len(m.params))
+		for i, input := range m.params {
+			values[i] = strings.TrimSpace(input.Value())
+		}
+		options, err := parseParams(values)
+		if err != nil {
+			m.status = "Error: " + err.Error()
+			return m, nil
+		}
+		m.session.client.Options = options
+		if err := m.session.saveSettings(); err != nil {
+			m.status = "Error: " + err.Error()
+			return m, nil
+		}
+		m.status = "Parameters saved"
+		m.modal = modalNone
+		return layout(m), nil
+
+	case msg.String() == "tab", msg.String() == "down":
+		return focusParam(m, m.paramFocus+1), nil
+
+	case msg.String() == "shift+tab", msg.String() == "up":
+		return focusParam(m, m.paramFocus-1), nil
+	}
+
+	var cmd tea.Cmd
+	m.params[m.paramFocus], cmd = m.params[m.paramFocus].Update(msg)
+	return m, cmd
+}
+
+func updateModal(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.modal {
+	case modalModels:
+		return updateModelPicker(m, msg)
+	case modalCompare:
+		return updateComparison(m, msg)
+	case modalTemplates:
+		return updateTemplatePicker(m, msg)
+	case modalTemplateVars:
+		return updateTemplateVars(m, msg)
+	}
+	return updateParams(m, msg)
+}
+
+func modalView(m model) string {
+	if m.modal == modalCompare {
+		return m.comparison.View()
+	}
+
+	var content string
+	switch m.modal {
+	case modalModels:
+		content = m.modelPicker.View()
+	case modalTemplates:
+		content = m.templatePicker.View()
+	case modalTemplateVars:
+		content = templateVarsView(m)
+	default:
+		fields := make([]string, len(m.params))
+		for i, input := range m.params {
+			fields[i] = input.View()
+		}
+		content = lipgloss.JoinVertical(lipgloss.Left,
+			m.styles.header.Render("Parameters for "+m.session.client.Model),
+			"",
+			strings.Join(fields, "\n"),
+		)
+	}
+	box := m.styles.focusedPane.Padding(0, 1).Render(content)
+	return lipgloss.Place(m.width, m.bodyHeight, lipgloss.Center, lipgloss.Center, box)
+}
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"termpilot/db"
+	"termpilot/models"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	queueDropCmd.Flags().Bool("all", false, "drop every pending prompt")
+
+	queueCmd.AddCommand(queueListCmd, queueRunCmd, queueDropCmd)
+	rootCmd.AddCommand(queueCmd)
+}
+
+// queuedError reports a prompt kept as pending because the model server was
+// unreachable.
+type queuedError struct {
+	conversationID string
+}
+
+func (e *queuedError) Error() string {
+	return fmt.Sprintf("ollama is unreachable, the prompt is queued in conversation %s; send it with termpilot queue run", e.conversationID)
+}
+
+// isUnreachable reports whether err comes from failing to connect to the
+// model server, rather than from the server itself.
+func isUnreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// queuePrompt stores prompt with its files and images as a pending message
+// at the end of conversation.
+func queuePrompt(conversation *models.Conversation, prompt string, opts chatOptions) error {
+	if conversation.Title == "" {
+		conversation.Title = firstRunes(prompt, 20)
+	}
+	conversation.Messages = append(conversation.Messages, models.Message{
+		Role:        "user",
+		Content:     prompt,
+		Pending:     true,
+		Images:      opts.images,
+		Attachments: opts.attachments,
+	})
+	if err := saveConversation(conversation); err != nil {
+		return err
+	}
+	return &queuedError{conversationID: conversation.ID}
+}
+
+// countPending returns the number of pending prompts in messages.
+func countPending(messages []models.Message) int {
+	count := 0
+	for _, message := range messages {
+		if message.Pending {
+			count++
+		}
+	}
+	return count
+}
+
+// sendPending sends the pending prompts of the conversation in order. Each
+// one moves to the end of the conversation, followed by its reply. It stops
+// at the first prompt that fails, which stays pending, and returns how many
+// were sent.
+func (s *chatSession) sendPending(ctx context.Context) (int, error) {
+	var pending, kept []models.Message
+	for _, message := range s.conversation.Messages {
+		if message.Pending {
+			pending = append(pending, message)
+		} else {
+			kept = append(kept, message)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	// Failing prompts are already queued, and the files and images queued
+	// for the next prompt wait for it
+	opts, attachments, images := s.opts, s.attachments, s.images
+	defer func() { s.opts, s.attachments, s.images = opts, attachments, images }()
+	s.opts.queue = false
+
+	s.conversation.Messages = kept
+	for i, message := range pending {
+		s.attachments, s.images = nil, nil
+		for _, attachment := range message.Attachments {
+			attachment.ID, attachment.MessageID = 0, 0
+			s.attachments = append(s.attachments, attachment)
+		}
+		for _, image := range message.Images {
+			image.ID, image.MessageID = 0, 0
+			s.images = append(s.images, image)
+		}
+
+		if _, err := s.send(ctx, message.Content); err != nil {
+			s.conversation.Messages = append(s.conversation.Messages, pending[i:]...)
+			return i, err
+		}
+		if err := db.DeleteMessages([]uint{message.ID}); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(pending), nil
+}
+
+// listPending prints the pending prompts with their conversation.
+func listPending(out io.Writer, messages []models.Message) {
+	if len(messages) == 0 {
+		fmt.Fprintln(out, "No pending prompts.")
+		return
+	}
+	for _, message := range messages {
+		prompt := strings.Join(strings.Fields(message.Content), " ")
+		if utf8.RuneCountInString(prompt) > 60 {
+			prompt = firstRunes(prompt, 57) + "..."
+		}
+		fmt.Fprintf(out, "  %d. [%s %s] %s (queued %s)\n", message.ID, message.ConversationID, message.Conversation.Title, prompt, message.CreatedAt.Format("2006-01-02 15:04"))
+	}
+}
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "List, send or drop the prompts queued while Ollama was unreachable",
+	Long: `With the queue config value or chat --queue, prompts that cannot reach
+Ollama are kept as pending messages of their conversation instead of being
+lost. Once the server is back, queue run sends them in order.`,
+}
+
+var queueListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the pending prompts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		messages, err := db.GetPendingMessages()
+		if err != nil {
+			log.Fatalf("Failed to get pending prompts: %v", err)
+		}
+		listPending(os.Stdout, messages)
+	},
+}
+
+var queueRunCmd = &cobra.Command{
+	Use:   "run [conversation-id...]",
+	Short: "Send the pending prompts, of all conversations or the given ones",
+	Run: func(cmd *cobra.Command, args []string) {
+		messages, err := db.GetPendingMessages()
+		if err != nil {
+			log.Fatalf("Failed to get pending prompts: %v", err)
+		}
+
+		// Conversations in the order their first prompt was queued
+		var ids []string
+		seen := map[string]bool{}
+		for _, message := range messages {
+			if !seen[message.ConversationID] {
+				seen[message.ConversationID] = true
+				ids = append(ids, message.ConversationID)
+			}
+		}
+		if len(args) > 0 {
+			ids = args
+		}
+		if len(ids) == 0 {
+			fmt.Println("No pending prompts.")
+			return
+		}
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		ollamaClient := getOllamaClient()
+		for _, id := range ids {
+			conversation, err := db.GetConversation(id)
+			if err != nil {
+				log.Fatalf("Failed to get conversation: %v", err)
+			}
+
+			sent, err := newChatSession(conversation, ollamaClient, chatOptions{}).sendPending(cmd.Context())
+			if err != nil {
+				log.Fatalf("Failed to send the pending prompts of %s after %d: %v", id, sent, err)
+			}
+			fmt.Printf("Sent %d pending prompts of %s %s\n", sent, conversation.ID, conversation.Title)
+		}
+	},
+}
+
+var queueDropCmd = &cobra.Command{
+	Use:   "drop [message-id...]",
+	Short: "Drop pending prompts without sending them",
+	Run: func(cmd *cobra.Command, args []string) {
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			log.Fatalf("Failed to get all: %v", err)
+		}
+		if !all && len(args) == 0 {
+			log.Fatalf("Give the IDs of the prompts to drop, as shown by termpilot queue list, or --all")
+		}
+
+		messages, err := db.GetPendingMessages()
+		if err != nil {
+			log.Fatalf("Failed to get pending prompts: %v", err)
+		}
+		pending := map[uint]bool{}
+		for _, message := range messages {
+			pending[message.ID] = true
+		}
+
+		var ids []uint
+		if all {
+			for _, message := range messages {
+				ids = append(ids, message.ID)
+			}
+		}
+		for _, arg := range args {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil || !pending[uint(id)] {
+				log.Fatalf("No pending prompt %s", arg)
+			}
+			ids = append(ids, uint(id))
+		}
+
+		if err := db.DeleteMessages(ids); err != nil {
+			log.Fatalf("Failed to drop pending prompts: %v", err)
+		}
+		fmt.Printf("Dropped %d pending prompts\n", len(ids))
+	},
+}
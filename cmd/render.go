@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"termpilot/models"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var selectedStyle = lipgloss.NewStyle().Reverse(true)
+
+type renderKey struct {
+	role     string
+	content  string
+	selected bool
+	pending  bool
+}
+
+// messageRenderer renders messages with glamour for the TUI viewport. Each
+// message is rendered once per width and then served from the cache.
+type messageRenderer struct {
+	style    string
+	roles    map[string]lipgloss.Style
+	width    int
+	renderer *glamour.TermRenderer
+	cache    map[renderKey]string
+}
+
+// newMessageRenderer renders markdown with the glamour style named style,
+// dark or light, and headers with the role styles.
+func newMessageRenderer(style string, roles map[string]lipgloss.Style) *messageRenderer {
+	return &messageRenderer{style: style, roles: roles, cache: map[renderKey]string{}}
+}
+
+func (r *messageRenderer) setWidth(width int) {
+	if width == r.width && r.renderer != nil {
+		return
+	}
+	r.width = width
+	r.cache = map[renderKey]string{}
+
+	renderer, err := glamour.NewTermRenderer(
+		glamour.WithStandardStyle(r.style),
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		renderer = nil
+	}
+	r.renderer = renderer
+}
+
+// messageMarkdown is the markdown shown for a message. Its code blocks are
+// the ones numbered on screen and picked when selecting.
+func messageMarkdown(message models.Message) string {
+	content := strings.TrimSpace(message.Content)
+	if message.Role == "tool" {
+		// Tool output is plain text rather than markdown
+		content = fmt.Sprintf("```\n%s\n```", content)
+	}
+	return content
+}
+
+// renderMessage renders one message. A selected message is highlighted and
+// has its code blocks numbered.
+func (r *messageRenderer) renderMessage(message models.Message, selected bool) string {
+	content := messageMarkdown(message)
+	if selected {
+		content = numberCodeBlocks(content)
+	}
+	content = describeImages(message) + describeAttachments(message) + describeToolCalls(message) + content
+
+	key := renderKey{role: message.Role, content: content, selected: selected, pending: message.Pending}
+	if rendered, ok := r.cache[key]; ok {
+		return rendered
+	}
+
+	rendered := content
+	if r.renderer != nil {
+		if out, err := r.renderer.Render(content); err == nil {
+			rendered = strings.Trim(out, "\n")
+		}
+	}
+
+	header := strings.ToUpper(message.Role)
+	if style, ok := r.roles[message.Role]; ok {
+		header = style.Render(header)
+	}
+	if message.Pending {
+		header += " (pending)"
+	}
+	if selected {
+		header = selectedStyle.Render("> ") + header
+	}
+
+	rendered = header + "\n" + rendered
+	r.cache[key] = rendered
+	return rendered
+}
+
+// render renders messages wrapped to width, highlighting the one at index
+// selected, if any. It also returns the line each message starts at.
+func (r *messageRenderer) render(messages []models.Message, width int, selected int) (string, []int) {
+	r.setWidth(width)
+
+	parts := make([]string, 0, len(messages))
+	offsets := make([]int, 0, len(messages))
+	line := 0
+	for i, message := range messages {
+		part := r.renderMessage(message, i == selected)
+		parts = append(parts, part)
+		offsets = append(offsets, line)
+		line += strings.Count(part, "\n") + 2
+	}
+	return strings.Join(parts, "\n\n"), offsets
+}
+
+// renderMarkdown renders content without a role header, wrapped to width.
+func (r *messageRenderer) renderMarkdown(content string, width int) string {
+	r.setWidth(width)
+
+	key := renderKey{content: content}
+	if rendered, ok := r.cache[key]; ok {
+		return rendered
+	}
+
+	rendered := strings.TrimSpace(content)
+	if r.renderer != nil {
+		if out, err := r.renderer.Render(rendered); err == nil {
+			rendered = strings.Trim(out, "\n")
+		}
+	}
+	r.cache[key] = rendered
+	return rendered
+}
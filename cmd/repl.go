@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"termpilot/models"
+	"termpilot/ollamaclient"
+
+	"github.com/chzyer/readline"
+)
+
+const multilineDelimiter = `"""`
+
+const replUsage = `
+Start and end multi-line input with """ on a line of its own.
+Tab completes commands and model names, Ctrl+C cancels a running generation, Ctrl+D exits.
+`
+
+type repl struct {
+	session *chatSession
+	out     io.Writer
+}
+
+func newConversation() *models.Conversation {
+	return &models.Conversation{ID: newConversationID()}
+}
+
+func newREPL(conversation *models.Conversation, ollamaClient *ollamaclient.OllamaClient, opts chatOptions, out io.Writer) *repl {
+	return &repl{session: newChatSession(conversation, ollamaClient, opts), out: out}
+}
+
+// send completes prompt, cancelling the request on Ctrl+C.
+func (r *repl) send(prompt string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if len(r.session.compareModels) > 0 {
+		results, err := r.session.compare(ctx, prompt)
+		if err != nil {
+			return err
+		}
+		printComparison(r.out, results)
+		fmt.Fprintf(r.out, "Keep a reply with /pick 1-%d, or /pick 0 for none.\n", len(results))
+		return nil
+	}
+
+	response, err := r.session.send(ctx, prompt)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(r.out, "Cancelled.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(r.out, fancyPrint(response))
+	return nil
+}
+
+func conversationMarkdown(conversation *models.Conversation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s - %s\n\n", conversation.ID, conversation.Title)
+	if conversation.SystemPrompt != "" {
+		fmt.Fprintf(&b, "## System:\n\n%s\n\n", conversation.SystemPrompt)
+	}
+	for _, message := range conversation.Messages {
+		role := []rune(message.Role)
+		role[0] = unicode.ToUpper(role[0])
+		fmt.Fprintf(&b, "## %s:\n\n%s%s%s\n\n", string(role), describeImages(message)+describeAttachments(message), describeToolCalls(message), strings.TrimSpace(message.Content))
+	}
+	return b.String()
+}
+
+// handleCommand runs a slash command and reports whether the session should end.
+func (r *repl) handleCommand(line string) (bool, error) {
+	output, err := runSlashCommand(r.session, line)
+	if err != nil {
+		return false, err
+	}
+
+	if output != "" {
+		fmt.Fprintln(r.out, strings.TrimRight(output, "\n"))
+	}
+	if strings.TrimSpace(line) == "/help" {
+		fmt.Fprint(r.out, replUsage)
+	}
+
+	if r.session.resend != "" {
+		prompt := r.session.resend
+		r.session.resend = ""
+		return false, r.send(prompt)
+	}
+	return r.session.quit, nil
+}
+
+// replCompleter adapts the slash command completion to readline.
+type replCompleter struct {
+	session *chatSession
+}
+
+func (c replCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	typed := string(line[:pos])
+	var suffixes [][]rune
+	for _, completion := range completeSlashCommand(c.session, typed) {
+		suffixes = append(suffixes, []rune(strings.TrimPrefix(completion, typed)))
+	}
+	return suffixes, 0
+}
+
+func (r *repl) run() error {
+	historyFile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyFile = filepath.Join(home, ".termpilot_history")
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "> ",
+		HistoryFile:     historyFile,
+		AutoComplete:    replCompleter{session: r.session},
+		InterruptPrompt: "^C",
+		EOFPrompt:       "/exit",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	fmt.Fprintf(r.out, "Conversation %s with %s. Type /help for commands.\n", r.session.conversation.ID, r.session.client.Model)
+
+	var multiline []string
+	inMultiline := false
+	for {
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			if inMultiline {
+				inMultiline, multiline = false, nil
+				rl.SetPrompt("> ")
+			}
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(line) == multilineDelimiter {
+			if !inMultiline {
+				inMultiline = true
+				rl.SetPrompt("... ")
+				continue
+			}
+			inMultiline = false
+			rl.SetPrompt("> ")
+			prompt := strings.Join(multiline, "\n")
+			multiline = nil
+			// Multi-line input is always a prompt, even if it starts with a slash
+			if strings.TrimSpace(prompt) != "" {
+				if err := r.send(prompt); err != nil {
+					fmt.Fprintln(r.out, "Error:", err)
+				}
+			}
+			continue
+		} else if inMultiline {
+			multiline = append(multiline, line)
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if isSlashCommand(line) {
+			quit, err := r.handleCommand(line)
+			if err != nil {
+				fmt.Fprintln(r.out, "Error:", err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+
+		if err := r.send(line); err != nil {
+			fmt.Fprintln(r.out, "Error:", err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"termpilot/db"
+	"termpilot/ollamaclient"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
@@ -17,12 +18,22 @@ var (
 		Use:   "termpilot",
 		Short: "Termpilot is a terminal based AI agent",
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if profileErr != nil {
+				log.Fatalf("Failed to apply profile: %v", profileErr)
+			}
 			if err := db.InitDB(); err != nil {
 				log.Fatalf("Failed to initialize database: %v", err)
 			}
 		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			stopOllama()
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			if _, err := tea.NewProgram(initialModel()).Run(); err != nil {
+			m, err := initialModel()
+			if err != nil {
+				log.Fatalf("Failed to set up the TUI: %v", err)
+			}
+			if _, err := tea.NewProgram(m).Run(); err != nil {
 				log.Fatalf("Error running TUI: %v", err)
 			}
 		},
@@ -40,15 +51,23 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.termpilot.yaml)")
+	rootCmd.PersistentFlags().String("profile", "", "profile of the config file to use")
 	rootCmd.PersistentFlags().String("model", "llama3.2", "model to use")
 	rootCmd.PersistentFlags().String("base-url", "http://localhost", "base url")
 	rootCmd.PersistentFlags().String("port", "11434", "port")
 	rootCmd.PersistentFlags().String("version", "v1", "version")
+	rootCmd.PersistentFlags().String("embed-model", ollamaclient.DefaultEmbedModel, "model used to compute embeddings")
+	rootCmd.PersistentFlags().String("embed-api", ollamaclient.EmbedAPINative, "embeddings endpoint to use (native or openai)")
+	rootCmd.PersistentFlags().Bool("stop-ollama", false, "stop an Ollama server started by this command when it ends")
 
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
 	viper.BindPFlag("model", rootCmd.PersistentFlags().Lookup("model"))
 	viper.BindPFlag("base-url", rootCmd.PersistentFlags().Lookup("base-url"))
 	viper.BindPFlag("port", rootCmd.PersistentFlags().Lookup("port"))
 	viper.BindPFlag("version", rootCmd.PersistentFlags().Lookup("version"))
+	viper.BindPFlag("embed-model", rootCmd.PersistentFlags().Lookup("embed-model"))
+	viper.BindPFlag("embed-api", rootCmd.PersistentFlags().Lookup("embed-api"))
+	viper.BindPFlag("stop-ollama", rootCmd.PersistentFlags().Lookup("stop-ollama"))
 
 	rootCmd.AddCommand(chatCmd)
 }
@@ -66,6 +85,8 @@ func initConfig() {
 		viper.SetConfigType("yaml")
 	}
 
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(envKeyReplacer)
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -73,4 +94,8 @@ func initConfig() {
 			log.Fatalf("Failed to read config file: %v", err)
 		}
 	}
+
+	// Reported by the commands using the config, not by config itself, so
+	// config set --profile can create the profile
+	profileErr = applyProfile(viper.GetString("profile"))
 }
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// startSelecting enters select mode on the last message of the conversation.
+func startSelecting(m model) model {
+	if len(m.session.conversation.Messages) == 0 {
+		m.status = "Nothing to select"
+		return m
+	}
+	m.selecting = true
+	m.selectedMsg = len(m.session.conversation.Messages) - 1
+	m.selectedBlock = 0
+	m.input.Blur()
+	m = layout(m)
+	return showSelection(m)
+}
+
+func stopSelecting(m model) model {
+	m.selecting = false
+	m.writingPath = false
+	m.status = ""
+	m.input.Focus()
+	m = layout(m)
+	return showMessages(m, m.session.conversation.Messages)
+}
+
+// showSelection re-renders the messages and scrolls to the selected one.
+func showSelection(m model) model {
+	content, offsets := renderViewport(m, m.session.conversation.Messages)
+	m.messages.SetContent(content)
+	m.messages.SetYOffset(offsets[m.selectedMsg])
+	m.status = selectionStatus(m)
+	return m
+}
+
+func selectionStatus(m model) string {
+	target := fmt.Sprintf("message %d", m.selectedMsg+1)
+	if m.selectedBlock > 0 {
+		target = fmt.Sprintf("code block %d of %s", m.selectedBlock, target)
+	}
+	return "Selected " + target
+}
+
+// selectionText is the text copied or written for the current selection.
+func selectionText(m model) string {
+	message := m.session.conversation.Messages[m.selectedMsg]
+	if m.selectedBlock == 0 {
+		return message.Content
+	}
+	return selectedBlocks(m)[m.selectedBlock-1].Code
+}
+
+// selectedBlocks returns the code blocks of the selected message, numbered
+// as they are on screen.
+func selectedBlocks(m model) []codeBlock {
+	return extractCodeBlocks(messageMarkdown(m.session.conversation.Messages[m.selectedMsg]))
+}
+
+func selectBlock(m model, block int) model {
+	blocks := selectedBlocks(m)
+	if block > len(blocks) {
+		m.status = fmt.Sprintf("Message %d has no code block %d", m.selectedMsg+1, block)
+		return m
+	}
+	m.selectedBlock = block
+	m.status = selectionStatus(m)
+	return m
+}
+
+func updateSelecting(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.writingPath {
+		return updateWritingPath(m, msg)
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.Back), key.Matches(msg, m.keys.Select):
+		return stopSelecting(m), nil
+
+	case key.Matches(msg, m.keys.Up):
+		if m.selectedMsg > 0 {
+			m.selectedMsg--
+			m.selectedBlock = 0
+		}
+		return showSelection(m), nil
+
+	case key.Matches(msg, m.keys.Down):
+		if m.selectedMsg < len(m.session.conversation.Messages)-1 {
+			m.selectedMsg++
+			m.selectedBlock = 0
+		}
+		return showSelection(m), nil
+
+	case key.Matches(msg, m.keys.NextBlock):
+		blocks := selectedBlocks(m)
+		return selectBlock(m, (m.selectedBlock+1)%(len(blocks)+1)), nil
+
+	case len(msg.Runes) == 1 && unicode.IsDigit(msg.Runes[0]):
+		return selectBlock(m, int(msg.Runes[0]-'0')), nil
+
+	case key.Matches(msg, m.keys.Copy):
+		via, err := copyToClipboard(selectionText(m))
+		if err != nil {
+			m.status = "Error: " + err.Error()
+			return m, nil
+		}
+		m.status = "Copied to the clipboard via " + via
+		return m, nil
+
+	case key.Matches(msg, m.keys.Write):
+		m.writingPath = true
+		m.pathInput.Prompt = "Write to: "
+		m.pathInput.Reset()
+		m.pathInput.Focus()
+		m.status = "Enter to write, Esc to cancel"
+		return m, nil
+	}
+
+	// Let the viewport scroll with the remaining keys
+	var cmd tea.Cmd
+	m.messages, cmd = m.messages.Update(msg)
+	return m, cmd
+}
+
+func updateWritingPath(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.writingPath = false
+		m.status = selectionStatus(m)
+		return m, nil
+
+	case "enter":
+		path := m.pathInput.Value()
+		if path == "" {
+			return m, nil
+		}
+		if err := os.WriteFile(path, []byte(selectionText(m)), 0644); err != nil {
+			m.status = "Error: " + err.Error()
+			return m, nil
+		}
+		m.writingPath = false
+		m.status = "Wrote " + path
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.pathInput, cmd = m.pathInput.Update(msg)
+	return m, cmd
+}
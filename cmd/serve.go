@@ -0,0 +1,378 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"termpilot/db"
+	"termpilot/models"
+	"termpilot/ollamaclient"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	serveTagPrefix = "serve:"
+	// maxRequestSize bounds request bodies, which may carry base64 images
+	maxRequestSize = 64 << 20
+)
+
+func init() {
+	serveCmd.Flags().String("listen", "localhost:8080", "address to listen on")
+	serveCmd.Flags().String("token", "", "bearer token required from clients (default is the serve-token config value)")
+	viper.BindPFlag("serve-token", serveCmd.Flags().Lookup("token"))
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+// proxyMessage is a chat message as OpenAI clients send it, with the content
+// either a string or a list of content parts.
+type proxyMessage struct {
+	Role       string                  `json:"role"`
+	Content    json.RawMessage         `json:"content"`
+	ToolCalls  []ollamaclient.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string                  `json:"tool_call_id,omitempty"`
+}
+
+type proxyRequest struct {
+	Model    string         `json:"model"`
+	Messages []proxyMessage `json:"messages"`
+	Stream   bool           `json:"stream"`
+}
+
+// streamChunk is one server-sent event of a streamed reply.
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// server answers OpenAI compatible requests by forwarding them to Ollama and
+// records every exchange as a conversation. It serves the conversations over
+// a REST API as well.
+type server struct {
+	client *ollamaclient.OllamaClient
+	// token, when set, is the bearer token clients must send
+	token string
+
+	mu sync.Mutex
+	// threads maps the key of every recorded history to its conversation, so
+	// a follow-up request sending the history again is appended to it
+	threads map[string]string
+}
+
+func newServer(client *ollamaclient.OllamaClient, token string) *server {
+	return &server{client: client, token: token, threads: map[string]string{}}
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.authorize(s.chatCompletions))
+	mux.HandleFunc("GET /v1/models", s.authorize(s.listModels))
+
+	mux.HandleFunc("GET /api/openapi.json", serveOpenAPI)
+	mux.HandleFunc("GET /api/conversations", s.authorize(s.listConversationsAPI))
+	mux.HandleFunc("POST /api/conversations", s.authorize(s.createConversationAPI))
+	mux.HandleFunc("GET /api/conversations/{id}", s.authorize(s.getConversationAPI))
+	mux.HandleFunc("POST /api/conversations/{id}/messages", s.authorize(s.continueConversationAPI))
+	mux.HandleFunc("DELETE /api/conversations/{id}", s.authorize(s.deleteConversationAPI))
+	return mux
+}
+
+// writeJSON writes v as the JSON body of a response with status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format of the OpenAI API.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"message": message, "type": http.StatusText(status)},
+	})
+}
+
+// clientName names the tool sending r, from the X-Termpilot-Client header or
+// else the product in its User-Agent.
+func clientName(r *http.Request) string {
+	if name := r.Header.Get("X-Termpilot-Client"); name != "" {
+		return name
+	}
+	product, _, _ := strings.Cut(r.UserAgent(), " ")
+	product, _, _ = strings.Cut(product, "/")
+	if product == "" {
+		return "unknown"
+	}
+	return product
+}
+
+// messageText returns the text of a message content, joining the text parts
+// of a content list.
+func messageText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(content, &parts)
+
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// historyKeys returns a key for every prefix of messages, the first one for
+// no message at all. Keys depend on the client and the system prompt too.
+func historyKeys(client string, system string, messages []models.Message) []string {
+	sum := sha256.Sum256([]byte(client + "\x00" + system))
+	keys := []string{string(sum[:])}
+	for _, message := range messages {
+		sum = sha256.Sum256([]byte(keys[len(keys)-1] + "\x00" + message.Role + "\x00" + message.Content))
+		keys = append(keys, string(sum[:]))
+	}
+	return keys
+}
+
+func (s *server) listModels(w http.ResponseWriter, r *http.Request) {
+	response, err := s.client.Forward(r.Context(), http.MethodGet, "models", nil)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer response.Body.Close()
+
+	w.Header().Set("Content-Type", response.Header.Get("Content-Type"))
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+}
+
+func (s *server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	var request proxyRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	// null decodes into the request above all the same
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields == nil {
+		writeError(w, http.StatusBadRequest, "invalid request: the body must be a JSON object")
+		return
+	}
+
+	// Clients that do not know the local models get the configured one
+	if request.Model == "" {
+		fields["model"], _ = json.Marshal(s.client.Model)
+		body, _ = json.Marshal(fields)
+		request.Model = s.client.Model
+	}
+
+	response, err := s.client.Forward(r.Context(), http.MethodPost, "chat/completions", bytes.NewReader(body))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer response.Body.Close()
+
+	w.Header().Set("Content-Type", response.Header.Get("Content-Type"))
+	w.WriteHeader(response.StatusCode)
+
+	relay := relayReply
+	if request.Stream {
+		relay = relayStream
+	}
+	reply, err := relay(w, response.Body)
+	if err != nil {
+		log.Printf("Failed to relay the reply: %v", err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		return
+	}
+
+	if err := s.record(clientName(r), request, reply); err != nil {
+		log.Printf("Failed to record the conversation: %v", err)
+	}
+}
+
+// relayReply copies a complete reply to w and returns its message.
+func relayReply(w http.ResponseWriter, body io.Reader) (models.Message, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return models.Message{}, err
+	}
+
+	var response ollamaclient.OllamaResponse
+	if err := json.Unmarshal(data, &response); err != nil || len(response.Choices) == 0 {
+		return models.Message{Role: "assistant"}, nil
+	}
+	return fromClientMessage(response.Choices[0].Message), nil
+}
+
+// relayStream copies a streamed reply to w event by event and returns the
+// message put together from its deltas.
+func relayStream(w http.ResponseWriter, body io.Reader) (models.Message, error) {
+	flusher, _ := w.(http.Flusher)
+	reader := bufio.NewReader(body)
+	var content strings.Builder
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, err := w.Write(line); err != nil {
+				return models.Message{}, err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+			data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+			var chunk streamChunk
+			if ok && json.Unmarshal(bytes.TrimSpace(data), &chunk) == nil {
+				for _, choice := range chunk.Choices {
+					content.WriteString(choice.Delta.Content)
+				}
+			}
+		}
+		if err == io.EOF {
+			return models.Message{Role: "assistant", Content: content.String()}, nil
+		}
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+}
+
+// record saves the exchange under the client's tag, appending it to the
+// conversation it continues when the history matches an earlier exchange.
+func (s *server) record(client string, request proxyRequest, reply models.Message) error {
+	var system string
+	var messages []models.Message
+	for i, message := range request.Messages {
+		text := messageText(message.Content)
+		if i == 0 && message.Role == "system" {
+			system = text
+			continue
+		}
+		messages = append(messages, fromClientMessage(ollamaclient.Message{
+			Role:       message.Role,
+			Content:    text,
+			ToolCalls:  message.ToolCalls,
+			ToolCallID: message.ToolCallID,
+		}))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation := &models.Conversation{
+		ID:           newConversationID(),
+		Tag:          serveTagPrefix + client,
+		SystemPrompt: system,
+		Model:        request.Model,
+	}
+	recorded := 0
+	keys := historyKeys(client, system, messages)
+	for n := len(messages) - 1; n > 0; n-- {
+		if id, ok := s.threads[keys[n]]; ok {
+			if existing, err := db.GetConversation(id); err == nil && len(existing.Messages) == n {
+				conversation, recorded = existing, n
+			}
+			break
+		}
+	}
+
+	if conversation.Title == "" {
+		for _, message := range messages {
+			if message.Role == "user" {
+				conversation.Title = firstRunes(message.Content, 20)
+				break
+			}
+		}
+	}
+	messages = append(messages, reply)
+	conversation.Messages = append(conversation.Messages, messages[recorded:]...)
+
+	if err := saveConversation(conversation); err != nil {
+		return err
+	}
+	s.threads[historyKeys(client, system, messages)[len(messages)]] = conversation.ID
+	return nil
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve an OpenAI compatible API that forwards to Ollama and records the conversations",
+	Long: `Serve /v1/chat/completions and /v1/models, forwarding requests to the
+configured Ollama, streamed replies included. Every exchange is saved as a
+conversation tagged serve:<client>, where the client is named by the
+X-Termpilot-Client header or else the User-Agent. Requests repeating the
+history of an earlier exchange continue its conversation.
+
+The conversations are served under /api/conversations as well, to list,
+search (?q=), fetch, start, continue and delete them; /api/openapi.json
+describes that API. With a token, every request but the description needs
+an "Authorization: Bearer <token>" header.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		listen, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			log.Fatalf("Failed to get listen: %v", err)
+		}
+
+		token := currentConfig().ServeToken
+
+		if err := startOllama(); err != nil {
+			log.Fatalf("Failed to start ollama: %v", err)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		client := getOllamaClient()
+		httpServer := &http.Server{Addr: listen, Handler: newServer(client, token).routes()}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			httpServer.Shutdown(shutdownCtx)
+		}()
+
+		fmt.Fprintf(os.Stderr, "Listening on %s, forwarding to %s:%s\n", listen, client.BaseURL, client.Port)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve: %v", err)
+		}
+	},
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+// The snippets export the last command line and its exit status after every
+// command so that `termpilot explain` can read them from its environment.
+const bashInit = `# termpilot shell integration for bash
+__termpilot_precmd() {
+  local last_status=$?
+  export TERMPILOT_LAST_STATUS=$last_status
+  export TERMPILOT_LAST_COMMAND="$(HISTTIMEFORMAT= builtin history 1 | sed -e 's/^ *[0-9]\{1,\}\*\{0,1\} *//')"
+  return $last_status
+}
+PROMPT_COMMAND="__termpilot_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+`
+
+const zshInit = `# termpilot shell integration for zsh
+__termpilot_precmd() {
+  local last_status=$?
+  export TERMPILOT_LAST_STATUS=$last_status
+  export TERMPILOT_LAST_COMMAND="$(fc -ln -1)"
+  return $last_status
+}
+precmd_functions=(__termpilot_precmd $precmd_functions)
+`
+
+const fishInit = `# termpilot shell integration for fish
+function __termpilot_postexec --on-event fish_postexec
+    set -gx TERMPILOT_LAST_STATUS $status
+    set -gx TERMPILOT_LAST_COMMAND $argv[1]
+end
+`
+
+var shellInits = map[string]string{
+	"bash": bashInit,
+	"zsh":  zshInit,
+	"fish": fishInit,
+}
+
+func init() {
+	rootCmd.AddCommand(shellInitCmd)
+}
+
+var shellInitCmd = &cobra.Command{
+	Use:   "shell-init <bash|zsh|fish>",
+	Short: "Print the shell integration used by `termpilot explain`",
+	Long: `Print the shell integration used by "termpilot explain". Add it to your shell startup file, e.g.
+
+  eval "$(termpilot shell-init bash)"    # ~/.bashrc
+  eval "$(termpilot shell-init zsh)"     # ~/.zshrc
+  termpilot shell-init fish | source     # ~/.config/fish/config.fish`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Printing the snippet does not need the database
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		snippet, ok := shellInits[args[0]]
+		if !ok {
+			log.Fatalf("Unsupported shell %q, expected bash, zsh or fish", args[0])
+		}
+		fmt.Print(snippet)
+	},
+}
@@ -0,0 +1,521 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"termpilot/db"
+	"termpilot/models"
+	"termpilot/ollamaclient"
+)
+
+// chatSession is the state slash commands act on. The REPL and the TUI both
+// keep one per open conversation.
+type chatSession struct {
+	conversation *models.Conversation
+	client       *ollamaclient.OllamaClient
+	// defaults apply to conversations without their own model or options
+	defaults ollamaclient.OllamaClient
+	opts     chatOptions
+	// attachments and images go with the next prompt
+	attachments []models.Attachment
+	images      []models.Image
+	models      []string
+	// resend is a prompt the caller should send again, set by /retry
+	resend string
+	quit   bool
+	// compareModels makes prompts go to several models, set by /compare
+	compareModels []string
+	pending       *pendingComparison
+}
+
+func newChatSession(conversation *models.Conversation, ollamaClient *ollamaclient.OllamaClient, opts chatOptions) *chatSession {
+	// Copy the client so /model and /temp do not leak into other users of it
+	client := *ollamaClient
+	s := &chatSession{client: &client, defaults: *ollamaClient, opts: opts}
+	// Files and images given up front go with the first prompt only
+	s.attachments, s.opts.attachments = opts.attachments, nil
+	s.images, s.opts.images = opts.images, nil
+	s.open(conversation)
+	return s
+}
+
+// open switches the session to conversation and to its model and options.
+func (s *chatSession) open(conversation *models.Conversation) {
+	s.conversation = conversation
+
+	s.client.Model = s.defaults.Model
+	if conversation.Model != "" {
+		s.client.Model = conversation.Model
+	}
+
+	options := s.defaults.Options
+	if conversation.Temperature != nil {
+		options.Temperature = conversation.Temperature
+	}
+	if conversation.TopP != nil {
+		options.TopP = conversation.TopP
+	}
+	if conversation.NumCtx != nil {
+		options.NumCtx = conversation.NumCtx
+	}
+	if conversation.Seed != nil {
+		options.Seed = conversation.Seed
+	}
+	s.client.Options = options
+}
+
+// saveSettings stores the model and options of the session with the
+// conversation.
+func (s *chatSession) saveSettings() error {
+	s.conversation.Model = s.client.Model
+	s.conversation.Temperature = s.client.Options.Temperature
+	s.conversation.TopP = s.client.Options.TopP
+	s.conversation.NumCtx = s.client.Options.NumCtx
+	s.conversation.Seed = s.client.Options.Seed
+
+	if s.conversation.CreatedAt.IsZero() {
+		return nil
+	}
+	return saveConversation(s.conversation)
+}
+
+// send completes prompt, with the queued files and images, in the session's
+// conversation.
+func (s *chatSession) send(ctx context.Context, prompt string) (string, error) {
+	opts := s.opts
+	opts.attachments, s.attachments = s.attachments, nil
+	opts.images, s.images = s.images, nil
+	return sendPrompt(ctx, s.conversation, prompt, opts, s.client)
+}
+
+// attachImage queues the image at path for the next prompt.
+func (s *chatSession) attachImage(path string) (string, error) {
+	image, err := loadImage(path)
+	if err != nil {
+		return "", err
+	}
+	s.images = append(s.images, image)
+	return fmt.Sprintf("%s will be attached to the next prompt.", image.Filename), nil
+}
+
+// dropLastExchange removes the last prompt and everything after it from the
+// conversation and returns the prompt.
+func (s *chatSession) dropLastExchange() (string, error) {
+	last := -1
+	for i, message := range s.conversation.Messages {
+		if message.Role == "user" {
+			last = i
+		}
+	}
+	if last == -1 {
+		return "", fmt.Errorf("nothing to retry")
+	}
+
+	prompt := s.conversation.Messages[last].Content
+	// The files and images go with the prompt again, stored anew
+	for _, attachment := range s.conversation.Messages[last].Attachments {
+		attachment.ID, attachment.MessageID = 0, 0
+		s.attachments = append(s.attachments, attachment)
+	}
+	for _, image := range s.conversation.Messages[last].Images {
+		image.ID, image.MessageID = 0, 0
+		s.images = append(s.images, image)
+	}
+	var ids []uint
+	for _, message := range s.conversation.Messages[last:] {
+		ids = append(ids, message.ID)
+	}
+	if err := db.DeleteMessages(ids); err != nil {
+		return "", err
+	}
+	s.conversation.Messages = s.conversation.Messages[:last]
+	return prompt, nil
+}
+
+// modelNames lists the available models once per session.
+func (s *chatSession) modelNames() []string {
+	if s.models == nil {
+		models, err := s.client.ListModels()
+		if err != nil {
+			return nil
+		}
+		s.models = models
+	}
+	return s.models
+}
+
+type slashCommand struct {
+	name        string
+	usage       string
+	description string
+	// complete returns candidates for the argument being typed
+	complete func(s *chatSession, arg string) []string
+	// run executes the command and returns a message for the user
+	run func(s *chatSession, arg string) (string, error)
+}
+
+var slashCommands []slashCommand
+
+func init() {
+	slashCommands = []slashCommand{
+		{
+			name:        "model",
+			usage:       "/model [name]",
+			description: "show or switch the model",
+			complete: func(s *chatSession, arg string) []string {
+				return filterPrefix(s.modelNames(), arg)
+			},
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg != "" {
+					s.client.Model = arg
+					if err := s.saveSettings(); err != nil {
+						return "", err
+					}
+				}
+				return "Model: " + s.client.Model, nil
+			},
+		},
+		{
+			name:        "temp",
+			usage:       "/temp [value]",
+			description: "show or set the sampling temperature",
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg != "" {
+					temperature, err := strconv.ParseFloat(arg, 64)
+					if err != nil || temperature < 0 || temperature > 2 {
+						return "", fmt.Errorf("temperature must be a number between 0 and 2")
+					}
+					s.client.Options.Temperature = &temperature
+					if err := s.saveSettings(); err != nil {
+						return "", err
+					}
+				}
+				if s.client.Options.Temperature == nil {
+					return "Temperature: model default", nil
+				}
+				return fmt.Sprintf("Temperature: %g", *s.client.Options.Temperature), nil
+			},
+		},
+		{
+			name:        "system",
+			usage:       "/system [prompt]",
+			description: "show or set the system prompt of the conversation",
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg == "" {
+					return "System prompt: " + s.conversation.SystemPrompt, nil
+				}
+				s.conversation.SystemPrompt = arg
+				if !s.conversation.CreatedAt.IsZero() {
+					if err := saveConversation(s.conversation); err != nil {
+						return "", err
+					}
+				}
+				return "System prompt set.", nil
+			},
+		},
+		{
+			name:        "file",
+			usage:       "/file <path>",
+			description: "attach a file to the next prompt",
+			complete:    completePath,
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg == "" {
+					return "", fmt.Errorf("usage: /file <path>")
+				}
+				attachment, err := loadAttachment(arg)
+				if err != nil {
+					return "", err
+				}
+				s.attachments = append(s.attachments, attachment)
+				return fmt.Sprintf("%s will be attached to the next prompt.", arg), nil
+			},
+		},
+		{
+			name:        "image",
+			usage:       "/image <path>",
+			description: "attach an image to the next prompt",
+			complete:    completePath,
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg == "" {
+					return "", fmt.Errorf("usage: /image <path>")
+				}
+				return s.attachImage(arg)
+			},
+		},
+		{
+			name:        "title",
+			usage:       "/title <title>",
+			description: "rename the conversation",
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg == "" {
+					return "", fmt.Errorf("usage: /title <title>")
+				}
+				s.conversation.Title = arg
+				if !s.conversation.CreatedAt.IsZero() {
+					if err := saveConversation(s.conversation); err != nil {
+						return "", err
+					}
+				}
+				return "Title: " + arg, nil
+			},
+		},
+		{
+			name:        "save",
+			usage:       "/save [title]",
+			description: "save the conversation, optionally renaming it",
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg != "" {
+					s.conversation.Title = arg
+				}
+				if err := saveConversation(s.conversation); err != nil {
+					return "", err
+				}
+				return "Saved conversation " + s.conversation.ID, nil
+			},
+		},
+		{
+			name:        "delete",
+			usage:       "/delete",
+			description: "delete the conversation and start a new one",
+			run: func(s *chatSession, arg string) (string, error) {
+				deleted := s.conversation.ID
+				if !s.conversation.CreatedAt.IsZero() {
+					if err := db.DeleteConversation(deleted); err != nil {
+						return "", err
+					}
+				}
+				s.conversation = newConversation()
+				if err := s.saveSettings(); err != nil {
+					return "", err
+				}
+				return "Deleted conversation " + deleted, nil
+			},
+		},
+		{
+			name:        "clear",
+			usage:       "/clear",
+			description: "start over in a new conversation",
+			run: func(s *chatSession, arg string) (string, error) {
+				system := s.conversation.SystemPrompt
+				s.conversation = newConversation()
+				s.conversation.SystemPrompt = system
+				if err := s.saveSettings(); err != nil {
+					return "", err
+				}
+				return "Started conversation " + s.conversation.ID, nil
+			},
+		},
+		{
+			name:        "retry",
+			usage:       "/retry",
+			description: "regenerate the last answer",
+			run: func(s *chatSession, arg string) (string, error) {
+				prompt, err := s.dropLastExchange()
+				if err != nil {
+					return "", err
+				}
+				s.resend = prompt
+				return "", nil
+			},
+		},
+		{
+			name:        "export",
+			usage:       "/export [path]",
+			description: "write the conversation as markdown to a file or show it",
+			complete:    completePath,
+			run: func(s *chatSession, arg string) (string, error) {
+				if arg == "" {
+					return conversationMarkdown(s.conversation), nil
+				}
+				if err := os.WriteFile(arg, []byte(conversationMarkdown(s.conversation)), 0644); err != nil {
+					return "", err
+				}
+				return "Exported to " + arg, nil
+			},
+		},
+		{
+			name:        "compare",
+			usage:       "/compare [a,b,...|off]",
+			description: "send the next prompts to several models and pick the best reply",
+			complete: func(s *chatSession, arg string) []string {
+				// Complete the model being typed after the last comma
+				done := arg[:strings.LastIndex(arg, ",")+1]
+				var completions []string
+				for _, name := range filterPrefix(s.modelNames(), arg[len(done):]) {
+					completions = append(completions, done+name)
+				}
+				return completions
+			},
+			run: func(s *chatSession, arg string) (string, error) {
+				switch arg {
+				case "":
+				case "off":
+					s.compareModels = nil
+				default:
+					names := parseModelList(arg)
+					if len(names) < 2 {
+						return "", fmt.Errorf("compare needs at least two models")
+					}
+					s.compareModels = names
+				}
+				if len(s.compareModels) == 0 {
+					return "Compare mode is off.", nil
+				}
+				return "Comparing " + strings.Join(s.compareModels, ", "), nil
+			},
+		},
+		{
+			name:        "pick",
+			usage:       "/pick <n>",
+			description: "keep reply n of the last comparison, 0 for none",
+			run: func(s *chatSession, arg string) (string, error) {
+				winner, err := strconv.Atoi(arg)
+				if err != nil {
+					return "", fmt.Errorf("usage: /pick <n>")
+				}
+				if err := s.pick(winner); err != nil {
+					return "", err
+				}
+				if winner == 0 {
+					return "Recorded the comparison without a winner.", nil
+				}
+				return "Kept reply " + arg + ".", nil
+			},
+		},
+		{
+			name:        "exit",
+			usage:       "/exit",
+			description: "leave the session",
+			run: func(s *chatSession, arg string) (string, error) {
+				s.quit = true
+				return "", nil
+			},
+		},
+		{
+			name:        "help",
+			usage:       "/help",
+			description: "list the commands",
+			run: func(s *chatSession, arg string) (string, error) {
+				return slashHelp(), nil
+			},
+		},
+	}
+}
+
+func slashHelp() string {
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, command := range slashCommands {
+		fmt.Fprintf(&b, "  %-18s %s\n", command.usage, command.description)
+	}
+	return b.String()
+}
+
+func isSlashCommand(line string) bool {
+	return strings.HasPrefix(line, "/")
+}
+
+func findSlashCommand(name string) (slashCommand, bool) {
+	for _, command := range slashCommands {
+		if command.name == name {
+			return command, true
+		}
+	}
+	return slashCommand{}, false
+}
+
+// runSlashCommand dispatches a line starting with / to its handler.
+func runSlashCommand(s *chatSession, line string) (string, error) {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "/"), " ")
+	if name == "quit" {
+		name = "exit"
+	}
+
+	command, ok := findSlashCommand(name)
+	if !ok {
+		return "", fmt.Errorf("unknown command /%s, try /help", name)
+	}
+	return command.run(s, strings.TrimSpace(arg))
+}
+
+// completeSlashCommand returns the possible completions of line, each being
+// a full replacement for it.
+func completeSlashCommand(s *chatSession, line string) []string {
+	if !isSlashCommand(line) {
+		return nil
+	}
+
+	name, arg, hasArg := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+	if !hasArg {
+		var completions []string
+		for _, command := range slashCommands {
+			if strings.HasPrefix(command.name, name) {
+				completions = append(completions, "/"+command.name+" ")
+			}
+		}
+		return completions
+	}
+
+	command, ok := findSlashCommand(name)
+	if !ok || command.complete == nil {
+		return nil
+	}
+
+	var completions []string
+	for _, candidate := range command.complete(s, arg) {
+		completions = append(completions, "/"+name+" "+candidate)
+	}
+	return completions
+}
+
+// commonPrefix returns the longest prefix shared by all values.
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	var matches []string
+	for _, value := range values {
+		if strings.HasPrefix(value, prefix) {
+			matches = append(matches, value)
+		}
+	}
+	return matches
+}
+
+func completePath(s *chatSession, arg string) []string {
+	dir, base := filepath.Split(arg)
+	entries, err := os.ReadDir(filepath.Join(".", dir))
+	if err != nil {
+		return nil
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), base) {
+			continue
+		}
+		match := dir + entry.Name()
+		if entry.IsDir() {
+			match += string(filepath.Separator)
+		}
+		matches = append(matches, match)
+	}
+	sort.Strings(matches)
+	return matches
+}
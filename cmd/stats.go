@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"termpilot/db"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+}
+
+// printLeaderboard ranks the models by the comparisons they won.
+func printLeaderboard(out io.Writer, stats []db.ModelStats) {
+	if len(stats) == 0 {
+		fmt.Fprintln(out, "No comparisons voted on yet, run termpilot compare first.")
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tMODEL\tWINS\tROUNDS\tWIN RATE\tAVG LATENCY\tTOK/S")
+	for i, s := range stats {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%.0f%%\t%.1fs\t%.1f\n",
+			i+1, s.Model, s.Wins, s.Rounds,
+			100*float64(s.Wins)/float64(max(s.Rounds, 1)),
+			s.AvgLatencyMs/1000, s.AvgTokensPerSec)
+	}
+	w.Flush()
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the leaderboard of models voted on with compare",
+	Run: func(cmd *cobra.Command, args []string) {
+		stats, err := db.GetModelStats()
+		if err != nil {
+			log.Fatalf("Failed to get model stats: %v", err)
+		}
+		printLeaderboard(os.Stdout, stats)
+	},
+}
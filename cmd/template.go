@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"termpilot/templates"
+
+	"github.com/spf13/cobra"
+)
+
+// Variables every template can read without being given them with --var.
+const (
+	inputVariable = "input"
+	filesVariable = "files"
+)
+
+func init() {
+	templateCmd.Flags().Bool("list", false, "list all templates")
+	templateCmd.Flags().String("edit", "", "create or edit a template in $EDITOR")
+	templateCmd.Flags().String("delete", "", "delete a template")
+	templateCmd.Flags().String("render", "", "print a template filled in with --var")
+	templateCmd.Flags().StringArray("var", nil, "template variable as name=value (repeatable)")
+
+	rootCmd.AddCommand(templateCmd)
+}
+
+// templateDir holds the templates, set with the template-dir config value.
+func templateDir() string {
+	if dir := currentConfig().TemplateDir; dir != "" {
+		return dir
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		log.Fatalf("Failed to get user config directory: %v", err)
+	}
+	return filepath.Join(configDir, "termpilot", "templates")
+}
+
+// fileBlocks formats files to be quoted in a prompt.
+func fileBlocks(paths []string) (string, error) {
+	var b strings.Builder
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(quoteFile(path, string(data)))
+	}
+	return b.String(), nil
+}
+
+// renderTemplate fills in tmpl with vars plus input and files. The input is
+// added after the template when it does not place it itself.
+func renderTemplate(tmpl *templates.Template, vars map[string]string, input string, files string) (string, error) {
+	all := map[string]string{inputVariable: input, filesVariable: files}
+	for name, value := range vars {
+		all[name] = value
+	}
+
+	prompt, err := tmpl.Render(all)
+	if err != nil {
+		return "", err
+	}
+	prompt = strings.TrimSpace(prompt)
+
+	if !tmpl.Uses(inputVariable) && input != "" {
+		prompt += "\n\n" + input
+	}
+	return prompt, nil
+}
+
+// userVariables are the variables of tmpl to ask the user for.
+func userVariables(tmpl *templates.Template) []string {
+	var names []string
+	for _, name := range tmpl.Variables {
+		if name != inputVariable && name != filesVariable {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// buildPrompt fills in the template name with args as its input. The files
+// are quoted where the template reads {{.files}}, otherwise they are returned
+// to be attached to the prompt.
+func buildPrompt(name string, assignments []string, paths []string, args []string) (string, []string, error) {
+	vars, err := templates.ParseVars(assignments)
+	if err != nil {
+		return "", nil, err
+	}
+	tmpl, err := templates.Load(templateDir(), name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	files, attach := "", paths
+	if tmpl.Uses(filesVariable) {
+		if files, err = fileBlocks(paths); err != nil {
+			return "", nil, err
+		}
+		attach = nil
+	}
+
+	prompt, err := renderTemplate(tmpl, vars, strings.Join(args, " "), files)
+	if err != nil {
+		return "", nil, err
+	}
+	return prompt, attach, nil
+}
+
+func listTemplates(out io.Writer, list []*templates.Template) {
+	if len(list) == 0 {
+		fmt.Fprintf(out, "No templates in %s, create one with termpilot template --edit <name>.\n", templateDir())
+		return
+	}
+	fmt.Fprintf(out, "Templates (%d):\n", len(list))
+	for _, tmpl := range list {
+		fmt.Fprintf(out, "  %s", tmpl.Name)
+		if tmpl.Description != "" {
+			fmt.Fprintf(out, " - %s", tmpl.Description)
+		}
+		if names := userVariables(tmpl); len(names) > 0 {
+			fmt.Fprintf(out, " [%s]", strings.Join(names, ", "))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+const newTemplateSource = `{{/* Describe the template here */}}
+Write the prompt here, reading variables like {{.lang}}.
+{{.files}}{{.input}}
+`
+
+// editTemplate opens the template name in the editor, starting from an
+// example when it does not exist, and checks that the result parses.
+func editTemplate(name string) error {
+	source := newTemplateSource
+	if tmpl, err := templates.Load(templateDir(), name); err == nil {
+		source = tmpl.Source
+	}
+
+	file, err := os.CreateTemp("", "termpilot-*"+templates.Ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(source); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	editor := editorCommand(file.Name())
+	editor.Stdin, editor.Stdout, editor.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := editor.Run(); err != nil {
+		return err
+	}
+
+	edited, err := os.ReadFile(file.Name())
+	if err != nil {
+		return err
+	}
+	return templates.Save(templateDir(), name, string(edited))
+}
+
+var templateCmd = &cobra.Command{
+	Use:   "template [name]",
+	Short: "Manage prompt templates",
+	Long: `Manage prompt templates stored in the template directory.
+
+Templates use Go text/template syntax and read variables given with --var as
+{{.name}}. {{.input}} holds the prompt, added after the template when it does
+not place it. {{.files}} quotes the files passed with -f, which are attached
+to the prompt instead when the template does not read it.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		edit, err := cmd.Flags().GetString("edit")
+		if err != nil {
+			log.Fatalf("Failed to get edit: %v", err)
+		}
+
+		if edit != "" {
+			if err := editTemplate(edit); err != nil {
+				log.Fatalf("Failed to edit template: %v", err)
+			}
+			fmt.Printf("Saved template %s\n", edit)
+			return
+		}
+
+		deleteName, err := cmd.Flags().GetString("delete")
+		if err != nil {
+			log.Fatalf("Failed to get delete: %v", err)
+		}
+
+		if deleteName != "" {
+			if err := templates.Delete(templateDir(), deleteName); err != nil {
+				log.Fatalf("Failed to delete template: %v", err)
+			}
+			fmt.Printf("Deleted template %s\n", deleteName)
+			return
+		}
+
+		render, err := cmd.Flags().GetString("render")
+		if err != nil {
+			log.Fatalf("Failed to get render: %v", err)
+		}
+
+		if render != "" {
+			vars, err := cmd.Flags().GetStringArray("var")
+			if err != nil {
+				log.Fatalf("Failed to get var: %v", err)
+			}
+			prompt, _, err := buildPrompt(render, vars, nil, nil)
+			if err != nil {
+				log.Fatalf("Failed to render template: %v", err)
+			}
+			fmt.Println(prompt)
+			return
+		}
+
+		if len(args) == 1 {
+			tmpl, err := templates.Load(templateDir(), args[0])
+			if err != nil {
+				log.Fatalf("Failed to get template: %v", err)
+			}
+			fmt.Printf("Variables: %s\n\n%s", strings.Join(userVariables(tmpl), ", "), tmpl.Source)
+			return
+		}
+
+		list, err := templates.List(templateDir())
+		if err != nil {
+			log.Fatalf("Failed to list templates: %v", err)
+		}
+		listTemplates(os.Stdout, list)
+	},
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"strings"
+
+	"termpilot/templates"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type templateItem struct {
+	*templates.Template
+}
+
+func (i templateItem) Title() string { return i.Name }
+func (i templateItem) Description() string {
+	description := i.Template.Description
+	if names := userVariables(i.Template); len(names) > 0 {
+		description = strings.TrimSpace(description + " [" + strings.Join(names, ", ") + "]")
+	}
+	return description
+}
+func (i templateItem) FilterValue() string { return i.Name }
+
+func newTemplatePicker() list.Model {
+	l := list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	l.Title = "Start from a template"
+	l.SetShowHelp(false)
+	l.KeyMap.Quit.SetEnabled(false)
+	l.KeyMap.ForceQuit.SetEnabled(false)
+	return l
+}
+
+// newChat opens a new conversation, offering the templates when there are any.
+func newChat(m model) model {
+	m = openConversation(m, newConversation())
+	available, err := templates.List(templateDir())
+	if err != nil || len(available) == 0 {
+		return m
+	}
+	return showTemplatePicker(m, available)
+}
+
+func openTemplatePicker(m model) model {
+	available, err := templates.List(templateDir())
+	if err != nil {
+		m.status = "Error: " + err.Error()
+		return m
+	}
+	if len(available) == 0 {
+		m.status = "No templates in " + templateDir()
+		return m
+	}
+	return showTemplatePicker(m, available)
+}
+
+func showTemplatePicker(m model, available []*templates.Template) model {
+	items := make([]list.Item, len(available))
+	for i, tmpl := range available {
+		items[i] = templateItem{tmpl}
+	}
+	m.templatePicker.ResetFilter()
+	m.templatePicker.SetItems(items)
+	m.templatePicker.Select(0)
+	m.modal = modalTemplates
+	return layout(m)
+}
+
+func updateTemplatePicker(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Keys go to the filter while one is typed
+	if m.templatePicker.FilterState() != list.Filtering {
+		switch {
+		case key.Matches(msg, m.keys.Back):
+			m.modal = modalNone
+			return layout(m), nil
+
+		case key.Matches(msg, m.keys.Open):
+			selected, ok := m.templatePicker.SelectedItem().(templateItem)
+			if !ok {
+				return m, nil
+			}
+			m.template = selected.Template
+			names := userVariables(m.template)
+			if len(names) == 0 {
+				return useTemplate(m, nil), nil
+			}
+
+			m.templateVars = make([]textinput.Model, len(names))
+			for i, name := range names {
+				input := textinput.New()
+				input.Prompt = name + ": "
+				m.templateVars[i] = input
+			}
+			m.templateFocus = 0
+			m.templateVars[0].Focus()
+			m.modal = modalTemplateVars
+			return layout(m), nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.templatePicker, cmd = m.templatePicker.Update(msg)
+	return m, cmd
+}
+
+// useTemplate fills in the chosen template, with the composer text as its
+// input, and leaves the result in the composer to be edited and sent.
+func useTemplate(m model, vars map[string]string) model {
+	prompt, err := renderTemplate(m.template, vars, strings.TrimSpace(m.input.Value()), "")
+	if err != nil {
+		m.status = "Error: " + err.Error()
+		return m
+	}
+	m.input.SetValue(prompt)
+	m.input.CursorEnd()
+	m.status = "Template " + m.template.Name
+	m.modal = modalNone
+	return setFocus(layout(m), focusChat)
+}
+
+func updateTemplateVars(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Back):
+		m.modal = modalTemplates
+		return layout(m), nil
+
+	case key.Matches(msg, m.keys.Send):
+		// Empty fields count as not given, so required ones are reported
+		vars := map[string]string{}
+		for i, name := range userVariables(m.template) {
+			if value := strings.TrimSpace(m.templateVars[i].Value()); value != "" {
+				vars[name] = value
+			}
+		}
+		return useTemplate(m, vars), nil
+
+	case msg.String() == "tab", msg.String() == "down":
+		return focusTemplateVar(m, m.templateFocus+1), nil
+
+	case msg.String() == "shift+tab", msg.String() == "up":
+		return focusTemplateVar(m, m.templateFocus-1), nil
+	}
+
+	var cmd tea.Cmd
+	m.templateVars[m.templateFocus], cmd = m.templateVars[m.templateFocus].Update(msg)
+	return m, cmd
+}
+
+func focusTemplateVar(m model, index int) model {
+	m.templateVars[m.templateFocus].Blur()
+	m.templateFocus = (index + len(m.templateVars)) % len(m.templateVars)
+	m.templateVars[m.templateFocus].Focus()
+	return m
+}
+
+func templateVarsView(m model) string {
+	fields := make([]string, len(m.templateVars))
+	for i, input := range m.templateVars {
+		fields[i] = input.View()
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		m.styles.header.Render("Variables of "+m.template.Name),
+		"",
+		strings.Join(fields, "\n"),
+	)
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/viper"
+)
+
+// theme holds the colours of the TUI, as ANSI numbers or hex values.
+type theme struct {
+	// Mode is auto, dark or light and also picks the markdown style
+	Mode          string `mapstructure:"mode"`
+	User          string `mapstructure:"user"`
+	Assistant     string `mapstructure:"assistant"`
+	System        string `mapstructure:"system"`
+	Tool          string `mapstructure:"tool"`
+	Header        string `mapstructure:"header"`
+	Status        string `mapstructure:"status"`
+	Border        string `mapstructure:"border"`
+	FocusedBorder string `mapstructure:"focused_border"`
+}
+
+var (
+	darkTheme = theme{
+		Mode:          "dark",
+		User:          "12",
+		Assistant:     "10",
+		System:        "8",
+		Tool:          "11",
+		Header:        "15",
+		Status:        "245",
+		Border:        "240",
+		FocusedBorder: "62",
+	}
+	lightTheme = theme{
+		Mode:          "light",
+		User:          "4",
+		Assistant:     "2",
+		System:        "244",
+		Tool:          "130",
+		Header:        "0",
+		Status:        "242",
+		Border:        "250",
+		FocusedBorder: "62",
+	}
+)
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func validColor(color string) bool {
+	if hexColor.MatchString(color) {
+		return true
+	}
+	n, err := strconv.Atoi(color)
+	return err == nil && n >= 0 && n <= 255
+}
+
+// loadTheme reads the theme section of the config on top of the defaults for
+// the terminal background, detected unless the mode says otherwise.
+func loadTheme() (theme, error) {
+	mode := viper.GetString("theme.mode")
+	switch mode {
+	case "", "auto":
+		mode = "light"
+		if lipgloss.HasDarkBackground() {
+			mode = "dark"
+		}
+	case "dark", "light":
+	default:
+		return theme{}, fmt.Errorf("unknown theme mode %q, expected auto, dark or light", mode)
+	}
+
+	t := lightTheme
+	if mode == "dark" {
+		t = darkTheme
+	}
+	if err := viper.UnmarshalKey("theme", &t); err != nil {
+		return t, err
+	}
+	t.Mode = mode
+
+	colors := map[string]string{
+		"user":           t.User,
+		"assistant":      t.Assistant,
+		"system":         t.System,
+		"tool":           t.Tool,
+		"header":         t.Header,
+		"status":         t.Status,
+		"border":         t.Border,
+		"focused_border": t.FocusedBorder,
+	}
+	for name, color := range colors {
+		if !validColor(color) {
+			return t, fmt.Errorf("invalid colour %q for theme.%s, use an ANSI number or a hex value", color, name)
+		}
+	}
+	return t, nil
+}
+
+// uiStyles are the lipgloss styles derived from a theme.
+type uiStyles struct {
+	roles       map[string]lipgloss.Style
+	header      lipgloss.Style
+	status      lipgloss.Style
+	pane        lipgloss.Style
+	focusedPane lipgloss.Style
+}
+
+func (t theme) styles() uiStyles {
+	pane := lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color(t.Border))
+	return uiStyles{
+		roles: map[string]lipgloss.Style{
+			"user":      lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(t.User)),
+			"assistant": lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(t.Assistant)),
+			"system":    lipgloss.NewStyle().Italic(true).Foreground(lipgloss.Color(t.System)),
+			"tool":      lipgloss.NewStyle().Foreground(lipgloss.Color(t.Tool)),
+		},
+		header:      lipgloss.NewStyle().Bold(true).Padding(0, 1).Foreground(lipgloss.Color(t.Header)),
+		status:      lipgloss.NewStyle().Padding(0, 1).Foreground(lipgloss.Color(t.Status)),
+		pane:        pane,
+		focusedPane: pane.BorderForeground(lipgloss.Color(t.FocusedBorder)),
+	}
+}
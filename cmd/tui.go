@@ -1,20 +1,24 @@
 package cmd
 
 import (
-	"crypto/sha256"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"termpilot/db"
 	"termpilot/models"
-	"termpilot/ollamaclient"
-	"time"
+	"termpilot/rag"
+	"termpilot/templates"
 
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/spf13/viper"
+	"github.com/charmbracelet/lipgloss"
 )
 
 type item struct {
@@ -29,58 +33,127 @@ func (i item) FilterValue() string { return i.title }
 type model struct {
 	conversations list.Model
 	messages      viewport.Model
-	input         textinput.Model
-	selectedConv  *models.Conversation
+	input         textarea.Model
+	help          help.Model
+	keys          keyMap
+	styles        uiStyles
 	width         int
 	height        int
-	state         uiState
-	ollamaClient  *ollamaclient.OllamaClient
+	bodyHeight    int
+	focus         focusArea
+	showSidebar   bool
+	renderer      *messageRenderer
+	session       *chatSession
+	status        string
+	// select mode picks a message or one of its code blocks to copy
+	selecting     bool
+	selectedMsg   int
+	selectedBlock int
+	writingPath   bool
+	pathInput     textinput.Model
+	modal         modalKind
+	modelPicker   list.Model
+	params        []textinput.Model
+	paramFocus    int
+	rag           ragOptions
+	ragEnabled    bool
+	// comparison shows the replies of a comparison in columns, rendered
+	// apart from the messages since they wrap to a different width
+	comparison viewport.Model
+	columns    *messageRenderer
+	// the template picker opens on new chats, then asks for the variables
+	templatePicker list.Model
+	template       *templates.Template
+	templateVars   []textinput.Model
+	templateFocus  int
+	// attaching asks for the path of an image in the path input
+	attaching bool
 }
 
-type uiState int
+type focusArea int
 
 const (
-	stateBrowsing uiState = iota
-	stateChatting
-	stateNewChat
+	focusSidebar focusArea = iota
+	focusChat
 )
 
-func getOllamaClient() *ollamaclient.OllamaClient {
-	return ollamaclient.NewOllamaClient(
-		viper.GetString("base-url"),
-		viper.GetString("model"),
-		viper.GetString("port"),
-		viper.GetString("version"),
-	)
+const (
+	composerHeight = 3
+	// minSplitWidth is the narrowest terminal showing both panes side by side
+	minSplitWidth   = 80
+	maxSidebarWidth = 36
+	// newChatDraftKey stores the draft of a conversation not created yet
+	newChatDraftKey = "new"
+)
+
+// defaultRagOptions uses the rag-index config value, falling back to the most
+// recently created index.
+func defaultRagOptions() ragOptions {
+	opts := ragOptions{index: currentConfig().RagIndex, topK: rag.DefaultTopK}
+	if opts.index == "" {
+		if indexes, err := db.GetAllIndexes(); err == nil && len(indexes) > 0 {
+			opts.index = indexes[0].Name
+		}
+	}
+	return opts
 }
 
-func initialModel() model {
-	convs, _ := db.GetAllConversations()
-	items := make([]list.Item, len(convs))
-	for i, conv := range convs {
-		items[i] = item{id: conv.ID, title: conv.Title}
+// initialModel sets up the TUI, reporting invalid keymap or theme settings.
+func initialModel() (model, error) {
+	keys, err := loadKeyMap()
+	if err != nil {
+		return model{}, err
+	}
+	theme, err := loadTheme()
+	if err != nil {
+		return model{}, err
 	}
+	styles := theme.styles()
 
-	l := list.New(items, list.NewDefaultDelegate(), 0, 0)
+	l := list.New(nil, list.NewDefaultDelegate(), 0, 0)
 	l.Title = "Conversations"
-
-	ti := textinput.New()
-	ti.Placeholder = "Type your message..."
-	ti.Focus()
+	l.SetShowHelp(false)
+	l.KeyMap.CursorUp = keys.Up
+	l.KeyMap.CursorDown = keys.Down
+	// Quitting is handled by the model, esc must not leave the TUI
+	l.KeyMap.Quit.SetEnabled(false)
+	l.KeyMap.ForceQuit.SetEnabled(false)
+
+	ti := textarea.New()
+	ti.Placeholder = "Type your message or a /command..."
+	ti.ShowLineNumbers = false
+	ti.CharLimit = 0
+	ti.SetHeight(composerHeight)
+	// Enter sends, so newlines need a modifier
+	ti.KeyMap.InsertNewline = keys.Newline
+	ti.Blur()
+
+	pi := textinput.New()
+	pi.Prompt = "Write to: "
+	pi.Placeholder = "path"
 
 	m := model{
 		conversations: l,
 		input:         ti,
-		state:         stateBrowsing,
-		ollamaClient:  getOllamaClient(),
+		help:          help.New(),
+		keys:          keys,
+		styles:        styles,
+		pathInput:     pi,
+		modelPicker:   newModelPicker(),
+		focus:         focusSidebar,
+		showSidebar:   true,
+		renderer:      newMessageRenderer(theme.Mode, styles.roles),
+		columns:       newMessageRenderer(theme.Mode, styles.roles),
+		session:       newChatSession(newConversation(), getOllamaClient(), chatOptions{}),
+		rag:           defaultRagOptions(),
 	}
 	m.messages = viewport.New(80, 20)
 	m.messages.HighPerformanceRendering = false
-	m.messages.SetContent("Loading conversations...")
-	m.messages.Style = m.messages.Style.
-		Margin(1, 2).
-		Padding(1, 1)
-	return m
+	m.messages.Style = m.messages.Style.Padding(0, 1)
+	m.comparison = viewport.New(80, 20)
+	m.templatePicker = newTemplatePicker()
+	m = refreshConversations(m)
+	return layout(m), nil
 }
 
 func (m model) Init() tea.Cmd {
@@ -91,53 +164,164 @@ func (m model) Init() tea.Cmd {
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch m.state {
-	case stateBrowsing:
-		return updateBrowsing(m, msg)
-	case stateChatting:
-		return updateChatting(m, msg)
-	case stateNewChat:
-		return updateNewChat(m, msg)
+	switch msg := msg.(type) {
+	case editorFinishedMsg:
+		return editorFinished(m, msg)
+
+	case modelsLoadedMsg:
+		return modelsLoaded(m, msg), nil
+
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		return layout(m), nil
+
+	case tea.KeyMsg:
+		if key.Matches(msg, m.keys.Quit) {
+			saveDraft(m)
+			return m, tea.Quit
+		}
+		if m.modal != modalNone {
+			return updateModal(m, msg)
+		}
+		if m.selecting {
+			return updateSelecting(m, msg)
+		}
+		if m.focus == focusSidebar {
+			return updateSidebar(m, msg)
+		}
+		return updateChat(m, msg)
 	}
-	return m, nil
+
+	var cmds []tea.Cmd
+	var cmd tea.Cmd
+	m.conversations, cmd = m.conversations.Update(msg)
+	cmds = append(cmds, cmd)
+	m.input, cmd = m.input.Update(msg)
+	cmds = append(cmds, cmd)
+	return m, tea.Batch(cmds...)
 }
 
 func (m model) View() string {
-	switch m.state {
-	case stateBrowsing:
-		return browsingView(m)
-	case stateChatting:
-		return chatView(m)
-	case stateNewChat:
-		return newChatView(m)
+	var body string
+	switch {
+	case m.modal != modalNone:
+		body = modalView(m)
+	case m.narrow() && m.focus == focusSidebar:
+		body = sidebarView(m)
+	case m.narrow() || !m.showSidebar:
+		body = chatView(m)
+	default:
+		body = lipgloss.JoinHorizontal(lipgloss.Top, sidebarView(m), chatView(m))
 	}
-	return ""
+	return lipgloss.JoinVertical(lipgloss.Left, headerView(m), body, statusView(m), m.help.View(helpFor(m)))
 }
 
-func updateBrowsing(m model, msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "q", "ctrl+c":
+// narrow reports whether the terminal only fits one pane at a time.
+func (m model) narrow() bool {
+	return m.width < minSplitWidth
+}
+
+func (m model) sidebarWidth() int {
+	switch {
+	case m.narrow():
+		return m.width
+	case !m.showSidebar:
+		return 0
+	}
+	return min(m.width/3, maxSidebarWidth)
+}
+
+// layout sizes the panes to the terminal.
+func layout(m model) model {
+	footer := lipgloss.Height(statusView(m)) + lipgloss.Height(m.help.View(helpFor(m)))
+	bodyHeight := max(m.height-lipgloss.Height(headerView(m))-footer, composerHeight+3)
+	m.bodyHeight = bodyHeight
+	frameWidth, frameHeight := m.styles.pane.GetFrameSize()
+	m.modelPicker.SetSize(min(60, m.width-4), max(bodyHeight-2, 1))
+	m.templatePicker.SetSize(min(60, m.width-4), max(bodyHeight-2, 1))
+
+	sidebarWidth := m.sidebarWidth()
+	m.conversations.SetSize(max(sidebarWidth-frameWidth, 0), bodyHeight-frameHeight)
+
+	chatWidth := m.width
+	if !m.narrow() {
+		chatWidth -= sidebarWidth
+	}
+	chatWidth = max(chatWidth-frameWidth, 10)
+	m.messages.Width = chatWidth
+	m.messages.Height = bodyHeight - frameHeight - composerHeight
+	m.input.SetWidth(chatWidth)
+	m.help.Width = m.width
+
+	m.comparison.Width = m.width
+	m.comparison.Height = bodyHeight
+	m.comparison.SetContent(comparisonColumns(m, m.width))
+
+	// Re-wrap the messages for the new width
+	content, _ := renderViewport(m, m.session.conversation.Messages)
+	m.messages.SetContent(content)
+	return m
+}
+
+func setFocus(m model, focus focusArea) model {
+	m.focus = focus
+	if focus == focusChat {
+		m.input.Focus()
+	} else {
+		m.input.Blur()
+		m.showSidebar = true
+	}
+	return layout(m)
+}
+
+// openConversation shows conversation in the chat pane, keeping the draft of
+// the previous one.
+func openConversation(m model, conversation *models.Conversation) model {
+	m = leaveInput(m)
+	m.session.open(conversation)
+	m.status = ""
+	if pending := countPending(conversation.Messages); pending > 0 {
+		m.status = fmt.Sprintf("%d pending prompts, %s sends them", pending, m.keys.Retry.Help().Key)
+	}
+	m = loadDraft(m)
+	m = showMessages(m, conversation.Messages)
+	return setFocus(m, focusChat)
+}
+
+func updateSidebar(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Keys go to the filter while one is typed
+	if m.conversations.FilterState() != list.Filtering {
+		switch {
+		case key.Matches(msg, m.keys.Open):
+			selected, ok := m.conversations.SelectedItem().(item)
+			if !ok {
+				return m, nil
+			}
+			conv, err := db.GetConversation(selected.id)
+			if err != nil {
+				m.status = "Error: " + err.Error()
+				return m, nil
+			}
+			return openConversation(m, conv), nil
+
+		case key.Matches(msg, m.keys.NewChat), key.Matches(msg, m.keys.QuickNew):
+			return newChat(m), nil
+
+		case key.Matches(msg, m.keys.SwitchFocus):
+			return setFocus(m, focusChat), nil
+
+		case key.Matches(msg, m.keys.ToggleSidebar):
+			m.showSidebar = false
+			return setFocus(m, focusChat), nil
+
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+			return layout(m), nil
+
+		case key.Matches(msg, m.keys.QuickQuit):
+			saveDraft(m)
 			return m, tea.Quit
-		case "enter":
-			selected := m.conversations.SelectedItem().(item)
-			conv, _ := db.GetConversation(selected.id)
-			m.selectedConv = conv
-			m.state = stateChatting
-			m.messages.SetContent(formatMessages(conv.Messages))
-			m.messages.GotoBottom()
-			return m, nil
-		case "n":
-			m.state = stateNewChat
-			m.input.Focus()
-			return m, nil
 		}
-	case tea.WindowSizeMsg:
-		m.width, m.height = msg.Width, msg.Height
-		m.conversations.SetSize(msg.Width, msg.Height-4)
-		m.messages.Width = msg.Width
-		m.messages.Height = msg.Height - 4
 	}
 
 	var cmd tea.Cmd
@@ -145,157 +329,359 @@ func updateBrowsing(m model, msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-func browsingView(m model) string {
-	return m.conversations.View()
+func headerView(m model) string {
+	title := m.session.conversation.Title
+	if title == "" {
+		title = "New chat"
+	}
+	return m.styles.header.MaxWidth(m.width).Render(fmt.Sprintf("Termpilot · %s · %s%s", m.session.client.Model, title, ragStatus(m)))
 }
 
-func chatView(m model) string {
-	return fmt.Sprintf(
-		"Chat: %s\n%s\n\n%s",
-		m.selectedConv.Title,
-		m.messages.View(),
-		m.input.View(),
-	)
+func statusView(m model) string {
+	return m.styles.status.MaxWidth(m.width).Render(m.status)
 }
 
-func newChatView(m model) string {
-	return fmt.Sprintf(
-		"New Chat\n\n%s\n\n%s",
-		"Type your message below (Press Esc to cancel)",
-		m.input.View(),
-	)
+func helpFor(m model) helpKeys {
+	switch {
+	case m.modal == modalModels:
+		return m.keys.pickerHelp()
+	case m.modal == modalParams:
+		return m.keys.paramsHelp()
+	case m.modal == modalCompare:
+		return m.keys.compareHelp()
+	case m.modal == modalTemplates:
+		return m.keys.pickerHelp()
+	case m.modal == modalTemplateVars:
+		return m.keys.templateVarsHelp()
+	case m.selecting:
+		return m.keys.selectHelp()
+	case m.focus == focusSidebar:
+		return m.keys.sidebarHelp()
+	}
+	return m.keys.chatHelp()
 }
 
-func updateNewChat(m model, msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
+func paneFor(m model, focus focusArea) lipgloss.Style {
+	if m.focus == focus {
+		return m.styles.focusedPane
+	}
+	return m.styles.pane
+}
 
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyEsc:
-			m.state = stateBrowsing
-			m.input.Reset()
-			return m, nil
+func sidebarView(m model) string {
+	return paneFor(m, focusSidebar).
+		Width(m.conversations.Width()).
+		Height(m.conversations.Height()).
+		Render(m.conversations.View())
+}
 
-		case tea.KeyEnter:
-			prompt := m.input.Value()
-			m.input.Reset()
+func ragStatus(m model) string {
+	if !m.ragEnabled {
+		return ""
+	}
+	return fmt.Sprintf(" [RAG: %s]", m.rag.index)
+}
 
-			response, err := m.ollamaClient.ChatCompletion(prompt, []ollamaclient.Message{})
-			if err != nil {
-				log.Printf("Chat error: %v", err)
-				return m, nil
-			}
+func chatOptionsFor(m model) chatOptions {
+	opts := chatOptions{queue: currentConfig().Queue}
+	if m.ragEnabled {
+		opts.rag = m.rag
+	}
+	return opts
+}
 
-			conv := models.Conversation{
-				ID:    fmt.Sprintf("%x", sha256.Sum256([]byte(time.Now().String())))[:8],
-				Title: prompt[:min(len(prompt), 20)],
-				Messages: []models.Message{
-					{Content: prompt, Role: "user"},
-					{Content: response, Role: "assistant"},
-				},
-			}
+func toggleRag(m model) model {
+	if m.rag.enabled() {
+		m.ragEnabled = !m.ragEnabled
+	}
+	return m
+}
 
-			if _, err := db.CreateConversation(conv); err != nil {
-				log.Printf("Save error: %v", err)
-			}
+// refreshConversations reloads the sidebar, keeping the open conversation
+// selected.
+func refreshConversations(m model) model {
+	convs, _ := db.GetAllConversations()
+	items := make([]list.Item, len(convs))
+	current := -1
+	for i, conv := range convs {
+		items[i] = item{id: conv.ID, title: conv.Title}
+		if conv.ID == m.session.conversation.ID {
+			current = i
+		}
+	}
+	m.conversations.SetItems(items)
+	if current >= 0 {
+		m.conversations.Select(current)
+	}
+	return m
+}
 
-			convs, _ := db.GetAllConversations()
-			items := make([]list.Item, len(convs))
-			for i, conv := range convs {
-				items[i] = item{id: conv.ID, title: conv.Title}
-			}
-			m.conversations.SetItems(items)
+// handleSlashInput runs a slash command typed into the input and shows its
+// output in the status line.
+func handleSlashInput(m model, line string) (model, tea.Cmd) {
+	output, err := runSlashCommand(m.session, line)
+	if err != nil {
+		m.status = "Error: " + err.Error()
+		return m, nil
+	}
+	m.status = strings.TrimSpace(output)
 
-			m.messages.SetContent(formatMessages(conv.Messages))
-			m.messages.GotoBottom()
+	if m.session.quit {
+		return m, tea.Quit
+	}
 
-			m.state = stateBrowsing
-			return m, nil
+	if m.session.resend != "" {
+		prompt := m.session.resend
+		m.session.resend = ""
+		return sendChatPrompt(m, prompt)
+	}
 
-		}
+	m = showMessages(m, m.session.conversation.Messages)
+	return refreshConversations(m), nil
+}
 
-	case tea.WindowSizeMsg:
-		m.width, m.height = msg.Width, msg.Height
+// completeInput completes a slash command in the input, listing the
+// candidates in the status line when there are several.
+func completeInput(m model) model {
+	completions := completeSlashCommand(m.session, m.input.Value())
+	switch len(completions) {
+	case 0:
+		return m
+	case 1:
+		m.input.SetValue(completions[0])
+		m.status = ""
+	default:
+		m.input.SetValue(commonPrefix(completions))
+		m.status = strings.Join(completions, "  ")
 	}
+	m.input.CursorEnd()
+	return m
+}
 
-	m.input, cmd = m.input.Update(msg)
+func sendChatPrompt(m model, prompt string) (model, tea.Cmd) {
+	if len(m.session.compareModels) > 0 {
+		return sendComparison(m, prompt)
+	}
+
+	m.session.opts = chatOptionsFor(m)
+	_, err := m.session.send(context.Background(), prompt)
+	var queued *queuedError
+	if errors.As(err, &queued) {
+		m.status = fmt.Sprintf("Ollama is unreachable, the prompt is queued; %s sends it", m.keys.Retry.Help().Key)
+		m = showMessages(m, m.session.conversation.Messages)
+		return refreshConversations(m), nil
+	}
+	if err != nil {
+		log.Printf("Chat error: %v", err)
+		m.status = "Error: " + err.Error()
+		// Keep the prompt so it can be sent again
+		m.input.SetValue(prompt)
+		return m, nil
+	}
+
+	m.status = ""
+	m = showMessages(m, m.session.conversation.Messages)
+	return refreshConversations(m), nil
+}
+
+// draftKey is the key the input of the open conversation is kept under.
+func draftKey(m model) string {
+	if m.session.conversation.CreatedAt.IsZero() {
+		return newChatDraftKey
+	}
+	return m.session.conversation.ID
+}
+
+func saveDraft(m model) {
+	if err := db.SaveDraft(draftKey(m), m.input.Value()); err != nil {
+		log.Printf("Failed to save draft: %v", err)
+	}
+}
+
+func loadDraft(m model) model {
+	draft, err := db.GetDraft(draftKey(m))
+	if err != nil {
+		log.Printf("Failed to load draft: %v", err)
+	}
+	m.input.SetValue(draft)
+	return m
+}
+
+// leaveInput keeps the unsent input as a draft before the view changes.
+func leaveInput(m model) model {
+	saveDraft(m)
+	m.input.Reset()
+	return m
+}
+
+// submit sends the input, or runs it when it is a slash command.
+func submit(m model, prompt string) (model, tea.Cmd) {
+	if strings.TrimSpace(prompt) == "" {
+		return m, nil
+	}
+
+	m.input.Reset()
+	saveDraft(m)
+
+	if isSlashCommand(prompt) {
+		return handleSlashInput(m, prompt)
+	}
+	return sendChatPrompt(m, prompt)
+}
+
+func editInput(m model) (model, tea.Cmd) {
+	cmd, err := openEditor(m.input.Value())
+	if err != nil {
+		m.status = "Error: " + err.Error()
+		return m, nil
+	}
 	return m, cmd
 }
 
-func updateChatting(m model, msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
+// editorFinished submits what was saved in the editor.
+func editorFinished(m model, msg editorFinishedMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.status = "Editor error: " + msg.err.Error()
+		return m, nil
+	}
+	if !msg.saved {
+		return m, nil
+	}
+	if strings.TrimSpace(msg.content) == "" {
+		m.input.Reset()
+		saveDraft(m)
+		return m, nil
+	}
+	return submit(m, msg.content)
+}
 
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "esc":
-			m.state = stateBrowsing
-			m.selectedConv = nil
-			m.messages.GotoBottom()
+func chatView(m model) string {
+	input := m.input.View()
+	if m.writingPath || m.attaching {
+		input = lipgloss.NewStyle().Height(composerHeight).Render(m.pathInput.View())
+	}
+	return paneFor(m, focusChat).Render(lipgloss.JoinVertical(lipgloss.Left, m.messages.View(), input))
+}
+
+// updateAttaching reads the path of an image to attach to the next prompt.
+func updateAttaching(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Back):
+		m.attaching = false
+		m.input.Focus()
+		return m, nil
+
+	case key.Matches(msg, m.keys.Send):
+		path := strings.TrimSpace(m.pathInput.Value())
+		if path == "" {
+			return m, nil
+		}
+		output, err := m.session.attachImage(path)
+		if err != nil {
+			m.status = "Error: " + err.Error()
 			return m, nil
+		}
+		m.attaching = false
+		m.input.Focus()
+		m.status = output
+		return m, nil
+	}
 
-		case "enter":
-			prompt := m.input.Value()
-			m.input.Reset()
+	var cmd tea.Cmd
+	m.pathInput, cmd = m.pathInput.Update(msg)
+	return m, cmd
+}
 
-			var messages []ollamaclient.Message
-			for _, msg := range m.selectedConv.Messages {
-				messages = append(messages, ollamaclient.Message{
-					Role:    msg.Role,
-					Content: msg.Content,
-				})
-			}
+func updateChat(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.attaching {
+		return updateAttaching(m, msg)
+	}
 
-			messages = append(messages, ollamaclient.Message{
-				Role:    "user",
-				Content: prompt,
-			})
+	switch {
+	case key.Matches(msg, m.keys.Complete) && isSlashCommand(m.input.Value()):
+		return completeInput(m), nil
 
-			response, err := m.ollamaClient.ChatCompletion(prompt, messages)
-			if err != nil {
-				log.Printf("Chat error: %v", err)
-				return m, nil
-			}
+	case key.Matches(msg, m.keys.SwitchFocus), key.Matches(msg, m.keys.Back):
+		return setFocus(m, focusSidebar), nil
 
-			m.selectedConv.Messages = append(m.selectedConv.Messages,
-				models.Message{Content: prompt, Role: "user"},
-				models.Message{Content: response, Role: "assistant"},
-			)
+	case key.Matches(msg, m.keys.ToggleSidebar):
+		m.showSidebar = !m.showSidebar
+		return layout(m), nil
 
-			if _, err := db.UpdateConversation(*m.selectedConv); err != nil {
-				log.Printf("Save error: %v", err)
-			}
+	case key.Matches(msg, m.keys.NewChat):
+		return newChat(m), nil
 
-			m.messages.SetContent(formatMessages(m.selectedConv.Messages))
-			m.messages.GotoBottom()
-			return m, tea.Batch(
-				cmd,
-				func() tea.Msg { return tea.WindowSizeMsg{Width: m.width, Height: m.height} },
-			)
+	case key.Matches(msg, m.keys.Templates):
+		return openTemplatePicker(m), nil
 
-		case "ctrl+c", "q":
-			return m, tea.Quit
-		}
+	case key.Matches(msg, m.keys.Attach):
+		m.attaching = true
+		m.pathInput.Prompt = "Attach image: "
+		m.pathInput.Reset()
+		m.pathInput.Focus()
+		m.input.Blur()
+		return m, nil
 
-	case tea.WindowSizeMsg:
-		m.width, m.height = msg.Width, msg.Height
-		m.messages.Width = msg.Width
-		m.messages.Height = msg.Height - 4 // Account for input field
+	case key.Matches(msg, m.keys.Select):
+		return startSelecting(m), nil
+
+	case key.Matches(msg, m.keys.Retry):
+		return sendPending(m), nil
+
+	case key.Matches(msg, m.keys.Models):
+		return openModelPicker(m)
+
+	case key.Matches(msg, m.keys.Parameters):
+		return openParams(m), nil
+
+	case key.Matches(msg, m.keys.ToggleRag):
+		return toggleRag(m), nil
+
+	case key.Matches(msg, m.keys.Editor):
+		return editInput(m)
+
+	case key.Matches(msg, m.keys.Send):
+		return submit(m, m.input.Value())
+
+	case msg.String() == "pgup", msg.String() == "pgdown":
+		var cmd tea.Cmd
+		m.messages, cmd = m.messages.Update(msg)
+		return m, cmd
 	}
 
+	var cmd tea.Cmd
 	m.input, cmd = m.input.Update(msg)
 	return m, cmd
 }
 
-func formatMessages(messages []models.Message) string {
-	var content strings.Builder
-	for _, msg := range messages {
-		content.WriteString(
-			fmt.Sprintf("**%s**\n%s\n\n",
-				strings.ToUpper(msg.Role),
-				strings.TrimSpace(msg.Content),
-			))
+// sendPending sends the queued prompts of the open conversation.
+func sendPending(m model) model {
+	m.session.opts = chatOptionsFor(m)
+	sent, err := m.session.sendPending(context.Background())
+	switch {
+	case err != nil:
+		m.status = fmt.Sprintf("Sent %d pending prompts, then: %v", sent, err)
+	case sent == 0:
+		m.status = "No pending prompts"
+	default:
+		m.status = fmt.Sprintf("Sent %d pending prompts", sent)
+	}
+	m = showMessages(m, m.session.conversation.Messages)
+	return refreshConversations(m)
+}
+
+// showMessages renders messages into the viewport and scrolls to the end.
+func showMessages(m model, messages []models.Message) model {
+	content, _ := renderViewport(m, messages)
+	m.messages.SetContent(content)
+	m.messages.GotoBottom()
+	return m
+}
+
+func renderViewport(m model, messages []models.Message) (string, []int) {
+	selected := -1
+	if m.selecting {
+		selected = m.selectedMsg
 	}
-	return content.String()
+	return m.renderer.render(messages, m.messages.Width-m.messages.Style.GetHorizontalFrameSize(), selected)
 }
@@ -15,7 +15,11 @@ var uiCmd = &cobra.Command{
 	Use:   "ui",
 	Short: "Start the interactive TUI",
 	Run: func(cmd *cobra.Command, args []string) {
-		if _, err := tea.NewProgram(initialModel()).Run(); err != nil {
+		m, err := initialModel()
+		if err != nil {
+			log.Fatalf("Failed to set up the TUI: %v", err)
+		}
+		if _, err := tea.NewProgram(m).Run(); err != nil {
 			log.Fatalf("Error running TUI: %v", err)
 		}
 	},
@@ -0,0 +1,23 @@
+package db
+
+import "termpilot/models"
+
+// GetAttachments lists the attachments of a conversation with their content,
+// in the order they were attached.
+func GetAttachments(conversationID string) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	err := DB.Preload("Blob").
+		Joins("JOIN messages ON messages.id = attachments.message_id").
+		Where("messages.conversation_id = ?", conversationID).
+		Order("attachments.id").
+		Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// PruneBlobs deletes the content no attachment refers to anymore.
+func PruneBlobs() error {
+	return DB.Exec("DELETE FROM blobs WHERE hash NOT IN (SELECT blob_hash FROM attachments)").Error
+}
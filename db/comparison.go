@@ -0,0 +1,48 @@
+package db
+
+import "termpilot/models"
+
+// ModelStats sums up how a model did in the comparisons with a winner.
+type ModelStats struct {
+	Model           string
+	Rounds          int
+	Wins            int
+	AvgLatencyMs    float64
+	AvgTokensPerSec float64
+}
+
+func SaveComparison(comparison models.Comparison) (*models.Comparison, error) {
+	if err := DB.Create(&comparison).Error; err != nil {
+		return nil, err
+	}
+	return &comparison, nil
+}
+
+func GetAllComparisons() ([]models.Comparison, error) {
+	var comparisons []models.Comparison
+	if err := DB.Preload("Candidates").Order("created_at DESC").Find(&comparisons).Error; err != nil {
+		return nil, err
+	}
+	return comparisons, nil
+}
+
+// GetModelStats ranks the models by their wins. Comparisons without a winner
+// and failed replies are left out.
+func GetModelStats() ([]ModelStats, error) {
+	var stats []ModelStats
+	err := DB.Table("candidates").
+		Select(`candidates.model AS model,
+			COUNT(*) AS rounds,
+			SUM(CASE WHEN comparisons.winner = candidates.model THEN 1 ELSE 0 END) AS wins,
+			COALESCE(AVG(candidates.latency_ms), 0) AS avg_latency_ms,
+			COALESCE(AVG(CASE WHEN candidates.latency_ms > 0 THEN candidates.completion_tokens * 1000.0 / candidates.latency_ms END), 0) AS avg_tokens_per_sec`).
+		Joins("JOIN comparisons ON comparisons.id = candidates.comparison_id").
+		Where("comparisons.winner != '' AND candidates.error = ''").
+		Group("candidates.model").
+		Order("wins DESC, rounds ASC, model ASC").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
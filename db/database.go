@@ -1,6 +1,8 @@
 package db
 
 import (
+	"fmt"
+	"strings"
 	"termpilot/models"
 
 	"gorm.io/driver/sqlite"
@@ -9,21 +11,51 @@ import (
 
 var DB *gorm.DB
 
+// ErrNotFound is returned when a record does not exist.
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// likeEscaper escapes the wildcards of LIKE patterns using ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 func InitDB() error {
+	return OpenDB("termpilot.db")
+}
+
+// OpenDB opens the SQLite database at path, creating and migrating it as
+// needed.
+func OpenDB(path string) error {
 	var err error
-	DB, err = gorm.Open(sqlite.Open("termpilot.db?_foreign_keys=on"), &gorm.Config{})
+	DB, err = gorm.Open(sqlite.Open(path+"?_foreign_keys=on"), &gorm.Config{})
 	if err != nil {
 		return err
 	}
 	// Explicitly enable foreign key constraints
 	DB.Exec("PRAGMA foreign_keys = ON")
-	DB.AutoMigrate(&models.Conversation{}, &models.Message{})
+	DB.AutoMigrate(&models.Conversation{}, &models.Message{}, &models.Index{}, &models.Chunk{}, &models.Draft{}, &models.Comparison{}, &models.Candidate{}, &models.Image{}, &models.Blob{}, &models.Attachment{})
 	return nil
 }
 
+// Check verifies the integrity of the database and returns the number of
+// conversations in it.
+func Check() (int64, error) {
+	var result string
+	if err := DB.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return 0, err
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	var count int64
+	if err := DB.Model(&models.Conversation{}).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func GetConversation(id string) (*models.Conversation, error) {
 	var conversation models.Conversation
-	if err := DB.Preload("Messages").Where("id = ?", id).First(&conversation).Error; err != nil {
+	if err := DB.Preload("Messages.Images").Preload("Messages.Attachments.Blob").Where("id = ?", id).First(&conversation).Error; err != nil {
 		return nil, err
 	}
 	return &conversation, nil
@@ -55,13 +87,52 @@ func DeleteConversation(id string) error {
 	if err := DB.Delete(&models.Conversation{}, "id = ?", id).Error; err != nil {
 		return err
 	}
-	return nil
+	return PruneBlobs()
 }
 
 func GetLastConversation() (*models.Conversation, error) {
 	var conversation models.Conversation
-	if err := DB.Order("created_at DESC").Preload("Messages").First(&conversation).Error; err != nil {
+	if err := DB.Order("created_at DESC").Preload("Messages.Images").Preload("Messages.Attachments.Blob").First(&conversation).Error; err != nil {
 		return nil, err
 	}
 	return &conversation, nil
 }
+
+func GetConversationsByTagPrefix(prefix string) ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	if err := DB.Where("substr(tag, 1, length(?)) = ?", prefix, prefix).Order("created_at DESC").Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// SearchConversations returns the conversations whose title or messages
+// contain query, most recently updated first.
+func SearchConversations(query string) ([]models.Conversation, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	var conversations []models.Conversation
+	if err := DB.Where(`title LIKE ? ESCAPE '\' OR id IN (SELECT conversation_id FROM messages WHERE content LIKE ? ESCAPE '\')`, pattern, pattern).Order("updated_at DESC").Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// GetPendingMessages returns the queued prompts of all conversations, oldest
+// first, with their conversation.
+func GetPendingMessages() ([]models.Message, error) {
+	var messages []models.Message
+	if err := DB.Preload("Conversation").Where("pending = ?", true).Order("id").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func DeleteMessages(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := DB.Delete(&models.Message{}, ids).Error; err != nil {
+		return err
+	}
+	return PruneBlobs()
+}
@@ -59,11 +59,64 @@ func TestDatabaseOperations(t *testing.T) {
 	assert.Equal(t, "Updated Title", updatedConv.Title)
 	assert.Equal(t, 3, len(updatedConv.Messages))
 
+	// Test checking the database
+	count, err := Check()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
 	// Test getting all conversations
 	allConvs, err := GetAllConversations()
 	assert.NoError(t, err)
 	assert.GreaterOrEqual(t, len(allConvs), 1)
 
+	// Test finding conversations by tag
+	_, err = CreateConversation(models.Conversation{ID: "tagged1", Title: "Tagged", Tag: "explain:ls /missing"})
+	assert.NoError(t, err)
+	tagged, err := GetConversationsByTagPrefix("explain:")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tagged))
+	assert.Equal(t, "tagged1", tagged[0].ID)
+	assert.NoError(t, DeleteConversation("tagged1"))
+
+	// Prefixes are matched by characters, exactly and with their wildcards literally
+	_, err = CreateConversation(models.Conversation{ID: "tagged2", Title: "Tagged", Tag: "serve:café_bot"})
+	assert.NoError(t, err)
+	tagged, err = GetConversationsByTagPrefix("serve:café")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tagged))
+	tagged, err = GetConversationsByTagPrefix("serve:café%")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(tagged))
+	tagged, err = GetConversationsByTagPrefix("serve:café_")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tagged))
+	assert.NoError(t, DeleteConversation("tagged2"))
+
+	_, err = CreateConversation(models.Conversation{ID: "tagged3", Title: "Tagged", Tag: "serve:Bot"})
+	assert.NoError(t, err)
+	_, err = CreateConversation(models.Conversation{ID: "tagged4", Title: "Tagged", Tag: "serve:bot"})
+	assert.NoError(t, err)
+	tagged, err = GetConversationsByTagPrefix("serve:b")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tagged))
+	assert.Equal(t, "tagged4", tagged[0].ID)
+	assert.NoError(t, DeleteConversation("tagged3"))
+	assert.NoError(t, DeleteConversation("tagged4"))
+
+	// Test getting queued prompts
+	_, err = CreateConversation(models.Conversation{ID: "queued", Title: "Queued", Messages: []models.Message{
+		{Role: "user", Content: "answered"},
+		{Role: "assistant", Content: "yes"},
+		{Role: "user", Content: "waiting", Pending: true},
+	}})
+	assert.NoError(t, err)
+	pending, err := GetPendingMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "waiting", pending[0].Content)
+	assert.Equal(t, "Queued", pending[0].Conversation.Title)
+	assert.NoError(t, DeleteConversation("queued"))
+
 	// Test getting last conversation
 	lastConv, err := GetLastConversation()
 	assert.NoError(t, err)
@@ -78,12 +131,251 @@ func TestDatabaseOperations(t *testing.T) {
 	assert.Error(t, err) // Should get an error now
 }
 
+func TestIndexOperations(t *testing.T) {
+	tempFile := "test_index.db"
+
+	// Setup
+	_, err := initTestDB(tempFile)
+	assert.NoError(t, err)
+
+	// Teardown
+	defer os.Remove(tempFile)
+
+	index := models.Index{
+		Name:  "docs",
+		Root:  "/tmp/docs",
+		Model: "nomic-embed-text",
+		Chunks: []models.Chunk{
+			{Source: "a.md", StartLine: 1, EndLine: 10, Content: "alpha"},
+			{Source: "b.md", StartLine: 1, EndLine: 5, Content: "beta"},
+		},
+	}
+
+	_, err = SaveIndex(index)
+	assert.NoError(t, err)
+
+	// Saving again replaces the previous chunks
+	index.Chunks = index.Chunks[:1]
+	_, err = SaveIndex(index)
+	assert.NoError(t, err)
+
+	fetched, err := GetIndex("docs")
+	assert.NoError(t, err)
+	assert.Equal(t, "nomic-embed-text", fetched.Model)
+	assert.Equal(t, 1, len(fetched.Chunks))
+
+	indexes, err := GetAllIndexes()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(indexes))
+
+	err = DeleteIndex("docs")
+	assert.NoError(t, err)
+
+	_, err = GetIndex("docs")
+	assert.Error(t, err)
+
+	var chunkCount int64
+	DB.Model(&models.Chunk{}).Count(&chunkCount)
+	assert.Equal(t, int64(0), chunkCount)
+}
+
+func TestDraftOperations(t *testing.T) {
+	tempFile := "test_draft.db"
+
+	// Setup
+	_, err := initTestDB(tempFile)
+	assert.NoError(t, err)
+
+	// Teardown
+	defer os.Remove(tempFile)
+
+	draft, err := GetDraft("test123")
+	assert.NoError(t, err)
+	assert.Equal(t, "", draft)
+
+	err = SaveDraft("test123", "first line\nsecond line")
+	assert.NoError(t, err)
+
+	// Saving again overwrites the draft
+	err = SaveDraft("test123", "updated")
+	assert.NoError(t, err)
+
+	draft, err = GetDraft("test123")
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", draft)
+
+	// An empty draft removes it
+	err = SaveDraft("test123", "")
+	assert.NoError(t, err)
+
+	var draftCount int64
+	DB.Model(&models.Draft{}).Count(&draftCount)
+	assert.Equal(t, int64(0), draftCount)
+}
+
+func TestComparisonOperations(t *testing.T) {
+	tempFile := "test_comparison.db"
+
+	// Setup
+	_, err := initTestDB(tempFile)
+	assert.NoError(t, err)
+
+	// Teardown
+	defer os.Remove(tempFile)
+
+	comparisons := []models.Comparison{
+		{Prompt: "one", Winner: "llama3", Candidates: []models.Candidate{
+			{Model: "llama3", LatencyMs: 1000, CompletionTokens: 50},
+			{Model: "mistral", LatencyMs: 2000, CompletionTokens: 50},
+		}},
+		{Prompt: "two", Winner: "llama3", Candidates: []models.Candidate{
+			{Model: "llama3", LatencyMs: 3000, CompletionTokens: 30},
+			{Model: "mistral", Error: "timeout"},
+		}},
+		// Comparisons without a vote do not count
+		{Prompt: "three", Candidates: []models.Candidate{
+			{Model: "llama3", LatencyMs: 1000, CompletionTokens: 10},
+			{Model: "mistral", LatencyMs: 1000, CompletionTokens: 10},
+		}},
+	}
+	for _, comparison := range comparisons {
+		_, err = SaveComparison(comparison)
+		assert.NoError(t, err)
+	}
+
+	all, err := GetAllComparisons()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(all))
+	assert.Equal(t, 2, len(all[0].Candidates))
+
+	stats, err := GetModelStats()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, ModelStats{Model: "llama3", Rounds: 2, Wins: 2, AvgLatencyMs: 2000, AvgTokensPerSec: 30}, stats[0])
+	assert.Equal(t, "mistral", stats[1].Model)
+	assert.Equal(t, 1, stats[1].Rounds)
+	assert.Equal(t, 0, stats[1].Wins)
+}
+
+func TestMessageImages(t *testing.T) {
+	tempFile := "test_images.db"
+
+	// Setup
+	_, err := initTestDB(tempFile)
+	assert.NoError(t, err)
+
+	// Teardown
+	defer os.Remove(tempFile)
+
+	_, err = CreateConversation(models.Conversation{
+		ID: "images",
+		Messages: []models.Message{
+			{Role: "user", Content: "What is this?", Images: []models.Image{
+				{Filename: "cat.png", MimeType: "image/png", Hash: "abc", Data: []byte{1, 2, 3}},
+			}},
+			{Role: "assistant", Content: "A cat."},
+		},
+	})
+	assert.NoError(t, err)
+
+	conversation, err := GetConversation("images")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(conversation.Messages[0].Images))
+	assert.Equal(t, "cat.png", conversation.Messages[0].Images[0].Filename)
+	assert.Equal(t, []byte{1, 2, 3}, conversation.Messages[0].Images[0].Data)
+
+	// Deleting the message deletes its images
+	DB.Exec("PRAGMA foreign_keys = ON")
+	assert.NoError(t, DeleteMessages([]uint{conversation.Messages[0].ID}))
+	var imageCount int64
+	DB.Model(&models.Image{}).Count(&imageCount)
+	assert.Equal(t, int64(0), imageCount)
+}
+
+func TestAttachmentOperations(t *testing.T) {
+	tempFile := "test_attachments.db"
+
+	// Setup
+	_, err := initTestDB(tempFile)
+	assert.NoError(t, err)
+	DB.Exec("PRAGMA foreign_keys = ON")
+
+	// Teardown
+	defer os.Remove(tempFile)
+
+	attachment := func(name string) models.Attachment {
+		return models.Attachment{Filename: name, BlobHash: "same", Blob: models.Blob{Hash: "same", Content: []byte("shared")}}
+	}
+	for _, id := range []string{"first", "second"} {
+		_, err = CreateConversation(models.Conversation{
+			ID: id,
+			Messages: []models.Message{
+				{Role: "user", Content: "Read these", Attachments: []models.Attachment{attachment("a.txt"), attachment("b.txt")}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	// The content is stored once for all attachments with the same hash
+	var blobCount int64
+	DB.Model(&models.Blob{}).Count(&blobCount)
+	assert.Equal(t, int64(1), blobCount)
+
+	attachments, err := GetAttachments("first")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(attachments))
+	assert.Equal(t, "a.txt", attachments[0].Filename)
+	assert.Equal(t, []byte("shared"), attachments[0].Blob.Content)
+
+	conversation, err := GetConversation("second")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("shared"), conversation.Messages[0].Attachments[1].Blob.Content)
+
+	// The content goes once no attachment refers to it
+	assert.NoError(t, DeleteConversation("first"))
+	DB.Model(&models.Blob{}).Count(&blobCount)
+	assert.Equal(t, int64(1), blobCount)
+	assert.NoError(t, DeleteConversation("second"))
+	DB.Model(&models.Blob{}).Count(&blobCount)
+	assert.Equal(t, int64(0), blobCount)
+}
+
+func TestSearchConversations(t *testing.T) {
+	tempFile := "test_search.db"
+
+	// Setup
+	_, err := initTestDB(tempFile)
+	assert.NoError(t, err)
+
+	// Teardown
+	defer os.Remove(tempFile)
+
+	_, err = CreateConversation(models.Conversation{ID: "title", Title: "Go generics", Messages: []models.Message{{Role: "user", Content: "Explain them"}}})
+	assert.NoError(t, err)
+	_, err = CreateConversation(models.Conversation{ID: "content", Title: "Question", Messages: []models.Message{{Role: "user", Content: "Are GENERICS slow? 100%"}}})
+	assert.NoError(t, err)
+
+	found, err := SearchConversations("generics")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(found))
+
+	// Wildcards are matched literally
+	found, err = SearchConversations("100%")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "content", found[0].ID)
+
+	found, err = SearchConversations("_")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(found))
+}
+
 func initTestDB(path string) (*gorm.DB, error) {
 	var err error
 	DB, err = gorm.Open(sqlite.Open(path), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
-	DB.AutoMigrate(&models.Conversation{}, &models.Message{})
+	DB.AutoMigrate(&models.Conversation{}, &models.Message{}, &models.Index{}, &models.Chunk{}, &models.Draft{}, &models.Comparison{}, &models.Candidate{}, &models.Image{}, &models.Blob{}, &models.Attachment{})
 	return DB, nil
 }
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"errors"
+
+	"termpilot/models"
+
+	"gorm.io/gorm"
+)
+
+// SaveDraft stores the draft of a conversation, removing it when content is empty.
+func SaveDraft(conversationID string, content string) error {
+	if content == "" {
+		return DeleteDraft(conversationID)
+	}
+	return DB.Save(&models.Draft{ConversationID: conversationID, Content: content}).Error
+}
+
+// GetDraft returns the draft of a conversation, or an empty string if there is none.
+func GetDraft(conversationID string) (string, error) {
+	var draft models.Draft
+	err := DB.Where("conversation_id = ?", conversationID).First(&draft).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return draft.Content, nil
+}
+
+func DeleteDraft(conversationID string) error {
+	return DB.Delete(&models.Draft{}, "conversation_id = ?", conversationID).Error
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"termpilot/models"
+
+	"gorm.io/gorm"
+)
+
+// SaveIndex replaces any existing index with the same name, including its chunks.
+func SaveIndex(index models.Index) (*models.Index, error) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("index_name = ?", index.Name).Delete(&models.Chunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Index{}, "name = ?", index.Name).Error; err != nil {
+			return err
+		}
+		return tx.Session(&gorm.Session{CreateBatchSize: 100}).Create(&index).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+func GetIndex(name string) (*models.Index, error) {
+	var index models.Index
+	if err := DB.Preload("Chunks").Where("name = ?", name).First(&index).Error; err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+func GetAllIndexes() ([]models.Index, error) {
+	var indexes []models.Index
+	if err := DB.Order("created_at DESC").Find(&indexes).Error; err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+func DeleteIndex(name string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("index_name = ?", name).Delete(&models.Chunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Index{}, "name = ?", name).Error
+	})
+}
@@ -0,0 +1,533 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema validates JSON documents against a JSON Schema. It covers the
+// keywords used to describe replies: type, enum, const, the numeric, string,
+// array and object constraints, allOf, anyOf, oneOf, not and local $refs.
+// Formats are not checked.
+type Schema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// ValidationError lists every place a document breaks the schema.
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Errors, "; ")
+}
+
+var types = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true,
+	"object": true, "array": true, "null": true,
+}
+
+// Compile parses a schema, checking its types, patterns and $refs.
+func Compile(data []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("invalid schema: must be an object or a boolean")
+	}
+
+	s := &Schema{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err := s.check(root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return s, nil
+}
+
+// Keywords whose values are schemas, lists of schemas or maps of names to
+// schemas. Other keywords hold data, even where it looks like a schema.
+var (
+	schemaKeywords = []string{"items", "additionalItems", "additionalProperties", "contains", "propertyNames", "not", "if", "then", "else"}
+	listKeywords   = []string{"allOf", "anyOf", "oneOf", "items", "prefixItems"}
+	mapKeywords    = []string{"properties", "patternProperties", "dependentSchemas", "$defs", "definitions"}
+)
+
+// subschemas returns the schemas found in the keywords of node.
+func subschemas(node map[string]interface{}) []interface{} {
+	var children []interface{}
+	for _, key := range schemaKeywords {
+		if child, ok := node[key].(map[string]interface{}); ok {
+			children = append(children, child)
+		}
+	}
+	for _, key := range listKeywords {
+		if list, ok := node[key].([]interface{}); ok {
+			children = append(children, list...)
+		}
+	}
+	for _, key := range mapKeywords {
+		if named, ok := node[key].(map[string]interface{}); ok {
+			for _, child := range named {
+				children = append(children, child)
+			}
+		}
+	}
+	return children
+}
+
+// check walks the schema to compile patterns and reject unknown types and
+// cyclic $refs.
+func (s *Schema) check(node interface{}) error {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		s.patterns[pattern] = compiled
+	}
+	for _, name := range typeNames(schema["type"]) {
+		if !types[name] {
+			return fmt.Errorf("unknown type %q", name)
+		}
+	}
+	if _, ok := schema["$ref"]; ok {
+		if err := s.checkRefs(schema, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range subschemas(schema) {
+		if err := s.check(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRefs rejects $refs leading back to themselves through keywords that
+// apply to the same value, which validation would follow forever. Refs
+// reached through properties or items move on to a smaller value and may
+// recurse.
+func (s *Schema) checkRefs(node interface{}, active map[string]bool) error {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if active[ref] {
+			return fmt.Errorf("$ref %q refers back to itself", ref)
+		}
+		// Missing targets are reported when validating
+		if target, err := s.resolve(ref); err == nil {
+			active[ref] = true
+			err := s.checkRefs(target, active)
+			delete(active, ref)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		list, _ := schema[key].([]interface{})
+		for _, child := range list {
+			if err := s.checkRefs(child, active); err != nil {
+				return err
+			}
+		}
+	}
+	return s.checkRefs(schema["not"], active)
+}
+
+func typeNames(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var names []string
+		for _, name := range value {
+			if name, ok := name.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// Validate checks that data is JSON matching the schema, returning a
+// ValidationError listing the mismatches.
+func (s *Schema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return &ValidationError{Errors: []string{fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	if decoder.More() {
+		return &ValidationError{Errors: []string{"invalid JSON: more than one value"}}
+	}
+
+	var errs []string
+	s.validate(s.root, value, "$", &errs)
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func (s *Schema) validate(schema interface{}, value interface{}, path string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	node, ok := schema.(map[string]interface{})
+	if !ok {
+		if schema == false {
+			fail("no value is allowed here")
+		}
+		return
+	}
+
+	if ref, ok := node["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		s.validate(target, value, path, errs)
+	}
+
+	if names := typeNames(node["type"]); len(names) > 0 && !matchesAnyType(value, names) {
+		fail("expected %s, got %s", strings.Join(names, " or "), typeOf(value))
+		// The other keywords would only repeat the mismatch
+		return
+	}
+
+	if enum, ok := node["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if equal(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compact(enum))
+		}
+	}
+	if allowed, ok := node["const"]; ok && !equal(allowed, value) {
+		fail("must be %s", compact(allowed))
+	}
+
+	switch value := value.(type) {
+	case json.Number:
+		s.validateNumber(node, value, fail)
+	case string:
+		s.validateString(node, value, fail)
+	case []interface{}:
+		s.validateArray(node, value, path, errs, fail)
+	case map[string]interface{}:
+		s.validateObject(node, value, path, errs, fail)
+	}
+
+	if allOf, ok := node["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			s.validate(sub, value, path, errs)
+		}
+	}
+	if anyOf, ok := node["anyOf"].([]interface{}); ok && s.countMatches(anyOf, value, path) == 0 {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if oneOf, ok := node["oneOf"].([]interface{}); ok {
+		if matches := s.countMatches(oneOf, value, path); matches != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", matches)
+		}
+	}
+	if not, ok := node["not"]; ok && s.matches(not, value, path) {
+		fail("must not match the not schema")
+	}
+}
+
+func (s *Schema) matches(schema interface{}, value interface{}, path string) bool {
+	var errs []string
+	s.validate(schema, value, path, &errs)
+	return len(errs) == 0
+}
+
+func (s *Schema) countMatches(schemas []interface{}, value interface{}, path string) int {
+	count := 0
+	for _, schema := range schemas {
+		if s.matches(schema, value, path) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Schema) validateNumber(node map[string]interface{}, value json.Number, fail func(string, ...interface{})) {
+	n, err := value.Float64()
+	if err != nil {
+		fail("invalid number %s", value)
+		return
+	}
+	if min, ok := node["minimum"].(float64); ok && n < min {
+		fail("must be at least %v", min)
+	}
+	if max, ok := node["maximum"].(float64); ok && n > max {
+		fail("must be at most %v", max)
+	}
+	if min, ok := node["exclusiveMinimum"].(float64); ok && n <= min {
+		fail("must be greater than %v", min)
+	}
+	if max, ok := node["exclusiveMaximum"].(float64); ok && n >= max {
+		fail("must be less than %v", max)
+	}
+	if multiple, ok := node["multipleOf"].(float64); ok && multiple > 0 {
+		if quotient := n / multiple; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			fail("must be a multiple of %v", multiple)
+		}
+	}
+}
+
+func (s *Schema) validateString(node map[string]interface{}, value string, fail func(string, ...interface{})) {
+	length := float64(utf8.RuneCountInString(value))
+	if min, ok := node["minLength"].(float64); ok && length < min {
+		fail("must be at least %v characters long", min)
+	}
+	if max, ok := node["maxLength"].(float64); ok && length > max {
+		fail("must be at most %v characters long", max)
+	}
+	if pattern, ok := node["pattern"].(string); ok {
+		// Patterns are compiled up front, except in schemas only reached
+		// through a $ref into an unknown keyword
+		compiled := s.patterns[pattern]
+		if compiled == nil {
+			var err error
+			if compiled, err = regexp.Compile(pattern); err != nil {
+				fail("invalid pattern %q: %v", pattern, err)
+				return
+			}
+		}
+		if !compiled.MatchString(value) {
+			fail("must match the pattern %q", pattern)
+		}
+	}
+}
+
+func (s *Schema) validateArray(node map[string]interface{}, value []interface{}, path string, errs *[]string, fail func(string, ...interface{})) {
+	length := float64(len(value))
+	if min, ok := node["minItems"].(float64); ok && length < min {
+		fail("must have at least %v items", min)
+	}
+	if max, ok := node["maxItems"].(float64); ok && length > max {
+		fail("must have at most %v items", max)
+	}
+	if unique, ok := node["uniqueItems"].(bool); ok && unique {
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if equal(value[i], value[j]) {
+					fail("items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+
+	switch items := node["items"].(type) {
+	case []interface{}:
+		// A list of schemas checks the items at the same positions
+		for i, item := range value {
+			if i < len(items) {
+				s.validate(items[i], item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case nil:
+	default:
+		for i, item := range value {
+			s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func (s *Schema) validateObject(node map[string]interface{}, value map[string]interface{}, path string, errs *[]string, fail func(string, ...interface{})) {
+	count := float64(len(value))
+	if min, ok := node["minProperties"].(float64); ok && count < min {
+		fail("must have at least %v properties", min)
+	}
+	if max, ok := node["maxProperties"].(float64); ok && count > max {
+		fail("must have at most %v properties", max)
+	}
+
+	if required, ok := node["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := value[name]; !present {
+					fail("missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := node["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		child := propertyPath(path, key)
+		if property, ok := properties[key]; ok {
+			s.validate(property, value[key], child, errs)
+			continue
+		}
+		switch additional := node["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				fail("unexpected property %q", key)
+			}
+		case map[string]interface{}:
+			s.validate(additional, value[key], child, errs)
+		}
+	}
+}
+
+// resolve finds a schema by a local JSON pointer like #/$defs/item.
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only local $refs are supported, got %q", ref)
+	}
+
+	node := s.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch current := node.(type) {
+		case map[string]interface{}:
+			node = current[token]
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+			node = current[index]
+		default:
+			node = nil
+		}
+		if node == nil {
+			return nil, fmt.Errorf("$ref %q not found", ref)
+		}
+	}
+	return node, nil
+}
+
+func matchesAnyType(value interface{}, names []string) bool {
+	for _, name := range names {
+		if matchesType(value, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(value interface{}, name string) bool {
+	switch value := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	case json.Number:
+		if name == "number" {
+			return true
+		}
+		if name == "integer" {
+			n, err := value.Float64()
+			return err == nil && n == math.Trunc(n)
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// equal compares JSON values, numbers by their value.
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		n, _ := value.Float64()
+		return n
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for i, item := range value {
+			normalized[i] = normalize(item)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			normalized[key] = normalize(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+func compact(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func propertyPath(path string, key string) string {
+	if identifier.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
@@ -0,0 +1,141 @@
+package jsonschema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0, "maximum": 150},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+		"address": {"$ref": "#/$defs/address"}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {
+		"address": {
+			"type": "object",
+			"properties": {"city": {"type": "string"}},
+			"required": ["city"]
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile([]byte(personSchema))
+	require.NoError(t, err)
+
+	assert.NoError(t, schema.Validate([]byte(`{"name": "Ada", "age": 36, "role": "admin", "tags": ["math"], "address": {"city": "London"}}`)))
+
+	err = schema.Validate([]byte(`{"name": "", "age": 36.5, "email": "nope", "role": "guest", "tags": ["a", "a"], "address": {}, "extra": 1}`))
+	var validation *ValidationError
+	require.ErrorAs(t, err, &validation)
+	assert.Equal(t, []string{
+		`$.address: missing required property "city"`,
+		`$.age: expected integer, got number`,
+		`$.email: must match the pattern "^[^@]+@[^@]+$"`,
+		`$: unexpected property "extra"`,
+		`$.name: must be at least 1 characters long`,
+		`$.role: must be one of ["admin","user"]`,
+		`$.tags: items 0 and 1 are equal`,
+	}, validation.Errors)
+
+	err = schema.Validate([]byte(`{"age": -1}`))
+	assert.EqualError(t, err, `$: missing required property "name"; $.age: must be at least 0`)
+
+	err = schema.Validate([]byte(`[1, 2]`))
+	assert.EqualError(t, err, "$: expected object, got array")
+
+	err = schema.Validate([]byte(`{"name": "Ada"`))
+	assert.ErrorContains(t, err, "invalid JSON")
+	err = schema.Validate([]byte(`{} {}`))
+	assert.ErrorContains(t, err, "more than one value")
+}
+
+func TestCombinators(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"type": "array",
+		"items": {
+			"oneOf": [
+				{"type": "string", "maxLength": 3},
+				{"type": "number", "multipleOf": 5}
+			],
+			"not": {"const": "bad"}
+		}
+	}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, schema.Validate([]byte(`["abc", 10, 2.5e1]`)))
+	err = schema.Validate([]byte(`["abcd", 7, "bad", null]`))
+	assert.EqualError(t, err, "$[0]: must match exactly one of the oneOf schemas, matched 0; "+
+		"$[1]: must match exactly one of the oneOf schemas, matched 0; "+
+		"$[2]: must not match the not schema; "+
+		"$[3]: must match exactly one of the oneOf schemas, matched 0")
+
+	anyOf, err := Compile([]byte(`{"anyOf": [{"type": "null"}, {"type": ["string", "boolean"]}]}`))
+	require.NoError(t, err)
+	assert.NoError(t, anyOf.Validate([]byte(`true`)))
+	assert.EqualError(t, anyOf.Validate([]byte(`1`)), "$: must match at least one of the anyOf schemas")
+}
+
+func TestCompile(t *testing.T) {
+	_, err := Compile([]byte(`{"type": "text"}`))
+	assert.EqualError(t, err, `invalid schema: unknown type "text"`)
+	_, err = Compile([]byte(`{"properties": {"a": {"pattern": "("}}}`))
+	assert.ErrorContains(t, err, "invalid schema")
+	_, err = Compile([]byte(`[]`))
+	assert.ErrorContains(t, err, "must be an object")
+
+	schema, err := Compile([]byte(`{"$ref": "#/definitions/missing"}`))
+	require.NoError(t, err)
+	assert.EqualError(t, schema.Validate([]byte(`1`)), `$: $ref "#/definitions/missing" not found`)
+
+	// Enum values are data, not schemas with unknown types
+	_, err = Compile([]byte(`{"enum": [{"type": "text"}]}`))
+	assert.NoError(t, err)
+	// Properties named like data keywords are schemas all the same
+	schema, err = Compile([]byte(`{"properties": {"default": {"type": "string", "pattern": "^a"}}}`))
+	require.NoError(t, err)
+	assert.NoError(t, schema.Validate([]byte(`{"default": "abc"}`)))
+	assert.EqualError(t, schema.Validate([]byte(`{"default": "b"}`)), `$.default: must match the pattern "^a"`)
+
+	// Patterns only reached through a $ref are compiled when needed
+	schema, err = Compile([]byte(`{"x-shared": {"id": {"pattern": "^[0-9]+$"}}, "$ref": "#/x-shared/id"}`))
+	require.NoError(t, err)
+	assert.NoError(t, schema.Validate([]byte(`"42"`)))
+	assert.EqualError(t, schema.Validate([]byte(`"x"`)), `$: must match the pattern "^[0-9]+$"`)
+}
+
+func TestRefCycles(t *testing.T) {
+	_, err := Compile([]byte(`{"$defs": {"a": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`))
+	assert.EqualError(t, err, `invalid schema: $ref "#/$defs/a" refers back to itself`)
+	_, err = Compile([]byte(`{"$defs": {"a": {"anyOf": [{"$ref": "#/$defs/b"}]}, "b": {"not": {"$ref": "#/$defs/a"}}}}`))
+	assert.ErrorContains(t, err, "refers back to itself")
+	_, err = Compile([]byte(`{"allOf": [{"$ref": "#"}]}`))
+	assert.ErrorContains(t, err, "refers back to itself")
+
+	// Recursion through properties and items checks ever smaller values
+	schema, err := Compile([]byte(`{
+		"$defs": {
+			"node": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}
+				},
+				"required": ["name"]
+			}
+		},
+		"$ref": "#/$defs/node"
+	}`))
+	require.NoError(t, err)
+	assert.NoError(t, schema.Validate([]byte(`{"name": "a", "children": [{"name": "b", "children": [{"name": "c"}]}]}`)))
+	assert.EqualError(t, schema.Validate([]byte(`{"name": "a", "children": [{"children": []}]}`)), `$.children[0]: missing required property "name"`)
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Attachment is a file attached to a message. Its content is kept in a Blob
+// shared by all attachments with the same hash.
+type Attachment struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	MessageID uint `gorm:"index"`
+	Filename  string
+	// Path is where the file was attached from
+	Path     string
+	MimeType string
+	Size     int64
+	BlobHash string `gorm:"index"`
+	Blob     Blob   `gorm:"foreignKey:BlobHash"`
+}
+
+// Blob is attachment content stored once per hex encoded SHA-256 hash.
+type Blob struct {
+	Hash      string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Content   []byte
+}
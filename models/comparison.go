@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Comparison is one prompt sent to several models, with the reply the user
+// picked as the winner.
+type Comparison struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	ConversationID string `gorm:"index"`
+	Prompt         string
+	Winner         string
+	Candidates     []Candidate `gorm:"foreignKey:ComparisonID;constraint:OnDelete:CASCADE;"`
+}
+
+type Candidate struct {
+	ID               uint `gorm:"primaryKey"`
+	ComparisonID     uint `gorm:"index"`
+	Model            string
+	Content          string
+	LatencyMs        int64
+	CompletionTokens int
+	Error            string
+}
@@ -3,11 +3,19 @@ package models
 import "time"
 
 type Conversation struct {
-	ID        string `gorm:"primaryKey"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Title     string
-	Messages  []Message `gorm:"foreignKey:ConversationID;constraint:OnDelete:CASCADE;"`
+	ID           string `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Title        string
+	Tag          string `gorm:"index"`
+	SystemPrompt string
+	// Model and the sampling parameters override the defaults when set
+	Model       string
+	Temperature *float64
+	TopP        *float64
+	NumCtx      *int
+	Seed        *int
+	Messages    []Message `gorm:"foreignKey:ConversationID;constraint:OnDelete:CASCADE;"`
 }
 
 type Message struct {
@@ -16,6 +24,12 @@ type Message struct {
 	UpdatedAt      time.Time
 	Content        string
 	Role           string
+	ToolCalls      string
+	ToolCallID     string
 	ConversationID string       `gorm:"index"`
 	Conversation   Conversation `gorm:"foreignKey:ConversationID;references:ID"`
+	Images         []Image      `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE;"`
+	Attachments    []Attachment `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE;"`
+	// Pending marks a prompt queued while the model server was unreachable
+	Pending bool `gorm:"index"`
 }
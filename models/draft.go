@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Draft is an unsent prompt, kept per conversation.
+type Draft struct {
+	ConversationID string `gorm:"primaryKey"`
+	UpdatedAt      time.Time
+	Content        string
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Image is a picture attached to a message for vision models.
+type Image struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	MessageID uint `gorm:"index"`
+	Filename  string
+	MimeType  string
+	// Hash is the hex encoded SHA-256 of Data
+	Hash string `gorm:"index"`
+	Data []byte
+}
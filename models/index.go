@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type Index struct {
+	Name      string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Root      string
+	Model     string
+	Chunks    []Chunk `gorm:"foreignKey:IndexName;references:Name;constraint:OnDelete:CASCADE;"`
+}
+
+type Chunk struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	IndexName string `gorm:"index"`
+	Source    string
+	StartLine int
+	EndLine   int
+	Content   string
+	Embedding []byte
+}
@@ -0,0 +1,139 @@
+package ollamaclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultEmbedModel     = "nomic-embed-text"
+	DefaultEmbedBatchSize = 32
+
+	// EmbedAPINative uses Ollama's /api/embed endpoint.
+	EmbedAPINative = "native"
+	// EmbedAPIOpenAI uses the OpenAI compatible /<version>/embeddings endpoint.
+	EmbedAPIOpenAI = "openai"
+)
+
+type EmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+type OpenAIEmbeddingResponse struct {
+	Object string `json:"object"`
+	Model  string `json:"model"`
+	Data   []struct {
+		Object    string    `json:"object"`
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed computes one embedding per input with the client's EmbedModel,
+// splitting the inputs into requests of at most EmbedBatchSize.
+func (c *OllamaClient) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	batchSize := c.EmbedBatchSize
+	if batchSize <= 0 {
+		batchSize = len(inputs)
+	}
+
+	embeddings := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); start += batchSize {
+		batch := inputs[start:min(start+batchSize, len(inputs))]
+
+		var batchEmbeddings [][]float32
+		var err error
+		switch c.EmbedAPI {
+		case EmbedAPIOpenAI:
+			batchEmbeddings, err = c.embedOpenAI(ctx, batch)
+		case EmbedAPINative, "":
+			batchEmbeddings, err = c.embedNative(ctx, batch)
+		default:
+			return nil, fmt.Errorf("unknown embed api %q", c.EmbedAPI)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(batchEmbeddings) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(batchEmbeddings))
+		}
+		embeddings = append(embeddings, batchEmbeddings...)
+	}
+
+	return embeddings, nil
+}
+
+func (c *OllamaClient) embedNative(ctx context.Context, inputs []string) ([][]float32, error) {
+	url := fmt.Sprintf("%s:%s/api/embed", c.BaseURL, c.Port)
+
+	var embedResponse EmbedResponse
+	if err := c.postEmbed(ctx, url, inputs, &embedResponse); err != nil {
+		return nil, err
+	}
+
+	return embedResponse.Embeddings, nil
+}
+
+func (c *OllamaClient) embedOpenAI(ctx context.Context, inputs []string) ([][]float32, error) {
+	url := fmt.Sprintf("%s:%s/%s/embeddings", c.BaseURL, c.Port, c.Version)
+
+	var embedResponse OpenAIEmbeddingResponse
+	if err := c.postEmbed(ctx, url, inputs, &embedResponse); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(embedResponse.Data, func(i, j int) bool {
+		return embedResponse.Data[i].Index < embedResponse.Data[j].Index
+	})
+
+	embeddings := make([][]float32, 0, len(embedResponse.Data))
+	for _, data := range embedResponse.Data {
+		embeddings = append(embeddings, data.Embedding)
+	}
+	return embeddings, nil
+}
+
+func (c *OllamaClient) postEmbed(ctx context.Context, url string, inputs []string, out interface{}) error {
+	requestBody := map[string]interface{}{
+		"model": c.EmbedModel,
+		"input": inputs,
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("embed request failed: %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,25 @@
+package ollamaclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Forward sends body as it is to endpoint of the OpenAI compatible API and
+// returns the response unread, so it can be passed on to another client,
+// streamed replies included. The caller closes the response body.
+func (c *OllamaClient) Forward(ctx context.Context, method string, endpoint string, body io.Reader) (*http.Response, error) {
+	url := fmt.Sprintf("%s:%s/%s/%s", c.BaseURL, c.Port, c.Version, endpoint)
+
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	return http.DefaultClient.Do(request)
+}
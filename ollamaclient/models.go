@@ -0,0 +1,62 @@
+package ollamaclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ModelInfo describes a model available on the Ollama server.
+type ModelInfo struct {
+	Name string
+	// Size is the size on disk in bytes
+	Size int64
+	// Loaded is true when the model is in memory and answers without delay
+	Loaded bool
+}
+
+type ollamaModelList struct {
+	Models []struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	} `json:"models"`
+}
+
+// ListModelDetails lists the models with their size and whether they are
+// loaded, using the native Ollama API rather than the OpenAI compatible one.
+func (c *OllamaClient) ListModelDetails() ([]ModelInfo, error) {
+	var tags ollamaModelList
+	if err := c.getNative("tags", &tags); err != nil {
+		return nil, err
+	}
+
+	var running ollamaModelList
+	if err := c.getNative("ps", &running); err != nil {
+		return nil, err
+	}
+	loaded := map[string]bool{}
+	for _, model := range running.Models {
+		loaded[model.Name] = true
+	}
+
+	models := make([]ModelInfo, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		models = append(models, ModelInfo{Name: model.Name, Size: model.Size, Loaded: loaded[model.Name]})
+	}
+	return models, nil
+}
+
+func (c *OllamaClient) getNative(endpoint string, v interface{}) error {
+	url := fmt.Sprintf("%s:%s/api/%s", c.BaseURL, c.Port, endpoint)
+
+	response, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
@@ -0,0 +1,112 @@
+package ollamaclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// nativeMessage is a message in the format of Ollama's /api/chat, which
+// takes images as base64 strings and tool call arguments as JSON objects.
+type nativeMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    [][]byte         `json:"images,omitempty"`
+	ToolCalls []nativeToolCall `json:"tool_calls,omitempty"`
+}
+
+type nativeToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type nativeChatResponse struct {
+	Model           string        `json:"model"`
+	Message         nativeMessage `json:"message"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// toNative converts messages to the /api/chat format.
+func toNative(messages []Message) []nativeMessage {
+	native := make([]nativeMessage, 0, len(messages))
+	for _, message := range messages {
+		converted := nativeMessage{Role: message.Role, Content: message.Content}
+		for _, image := range message.Images {
+			converted.Images = append(converted.Images, image.Data)
+		}
+		for _, call := range message.ToolCalls {
+			var nativeCall nativeToolCall
+			nativeCall.ID = call.ID
+			nativeCall.Function.Name = call.Function.Name
+			nativeCall.Function.Arguments = json.RawMessage(call.Function.Arguments)
+			if !json.Valid(nativeCall.Function.Arguments) {
+				nativeCall.Function.Arguments = json.RawMessage("{}")
+			}
+			converted.ToolCalls = append(converted.ToolCalls, nativeCall)
+		}
+		native = append(native, converted)
+	}
+	return native
+}
+
+// chatNative sends an OpenAI style request body to Ollama's /api/chat, the
+// only chat endpoint reading options such as num_ctx, and converts the reply
+// back to the OpenAI format.
+func (c *OllamaClient) chatNative(ctx context.Context, requestBody map[string]interface{}) (*OllamaResponse, error) {
+	url := fmt.Sprintf("%s:%s/api/chat", c.BaseURL, c.Port)
+
+	messages, _ := requestBody["messages"].([]Message)
+	nativeBody := map[string]interface{}{
+		"model":    requestBody["model"],
+		"messages": toNative(messages),
+		"stream":   false,
+		"options":  c.Options,
+	}
+	if tools, ok := requestBody["tools"]; ok {
+		nativeBody["tools"] = tools
+	}
+	if responseFormat, ok := requestBody["response_format"].(map[string]interface{}); ok {
+		nativeBody["format"] = "json"
+		if jsonSchema, ok := responseFormat["json_schema"].(map[string]interface{}); ok {
+			nativeBody["format"] = jsonSchema["schema"]
+		}
+	}
+
+	var nativeResponse nativeChatResponse
+	if err := c.postChat(ctx, url, nativeBody, &nativeResponse); err != nil {
+		return nil, err
+	}
+	if nativeResponse.Error != "" {
+		return nil, errors.New(nativeResponse.Error)
+	}
+
+	message := Message{Role: nativeResponse.Message.Role, Content: nativeResponse.Message.Content}
+	finishReason := nativeResponse.DoneReason
+	for i, nativeCall := range nativeResponse.Message.ToolCalls {
+		call := ToolCall{ID: nativeCall.ID, Type: "function"}
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d", i)
+		}
+		call.Function.Name = nativeCall.Function.Name
+		call.Function.Arguments = string(nativeCall.Function.Arguments)
+		message.ToolCalls = append(message.ToolCalls, call)
+		finishReason = "tool_calls"
+	}
+
+	return &OllamaResponse{
+		Model:   nativeResponse.Model,
+		Choices: []Choice{{Message: message, FinishReason: finishReason}},
+		Usage: Usage{
+			PromptTokens:     nativeResponse.PromptEvalCount,
+			CompletionTokens: nativeResponse.EvalCount,
+			TotalTokens:      nativeResponse.PromptEvalCount + nativeResponse.EvalCount,
+		},
+	}, nil
+}
@@ -2,29 +2,89 @@ package ollamaclient
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Images are sent along with Content to vision models
+	Images []Image `json:"-"`
+}
+
+// Image is a picture sent base64 encoded in a data URL.
+type Image struct {
+	MimeType string
+	Data     []byte
+}
+
+func (i Image) DataURL() string {
+	return "data:" + i.MimeType + ";base64," + base64.StdEncoding.EncodeToString(i.Data)
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+// MarshalJSON turns a message with images into OpenAI content parts, the
+// text followed by one image_url part per image.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type plain Message
+	if len(m.Images) == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	parts := []contentPart{{Type: "text", Text: m.Content}}
+	for _, image := range m.Images {
+		parts = append(parts, contentPart{Type: "image_url", ImageURL: &imageURL{URL: image.DataURL()}})
+	}
+	return json.Marshal(struct {
+		plain
+		Content []contentPart `json:"content"`
+	}{plain(m), parts})
 }
 
 type OllamaResponse struct {
-	ID      string `json:"id"`
-	Model   string `json:"model"`
-	Created int64  `json:"created"`
-	Choices []struct {
-		Index   int `json:"index"`
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+	ID      string   `json:"id"`
+	Model   string   `json:"model"`
+	Created int64    `json:"created"`
+	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
+}
+
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// Usage counts the tokens of a request and its reply.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Completion is a reply along with how long it took and the tokens it used.
+type Completion struct {
+	Content  string
+	Duration time.Duration
+	Usage    Usage
 }
 
 type OllamaModelResponse struct {
@@ -37,25 +97,46 @@ type OllamaModelResponse struct {
 	} `json:"data"`
 }
 
+// Options are sampling parameters sent with chat requests. Unset values
+// leave the server defaults in place.
+type Options struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	// NumCtx is the context window in tokens, an Ollama specific option
+	NumCtx *int `json:"num_ctx,omitempty"`
+	Seed   *int `json:"seed,omitempty"`
+}
+
 type OllamaClient struct {
-	BaseURL string
-	Port    string
-	Version string
-	Model   string
+	BaseURL        string
+	Port           string
+	Version        string
+	Model          string
+	EmbedModel     string
+	EmbedAPI       string
+	EmbedBatchSize int
+	Options        Options
 }
 
 func NewOllamaClient(baseURL string, model string, port string, version string) *OllamaClient {
 	return &OllamaClient{
-		BaseURL: baseURL,
-		Port:    port,
-		Version: version,
-		Model:   model,
+		BaseURL:        baseURL,
+		Port:           port,
+		Version:        version,
+		Model:          model,
+		EmbedModel:     DefaultEmbedModel,
+		EmbedAPI:       EmbedAPINative,
+		EmbedBatchSize: DefaultEmbedBatchSize,
 	}
 }
 
 func (c *OllamaClient) ChatCompletion(prompt string, messages []Message) (string, error) {
-	url := fmt.Sprintf("%s:%s/%s/chat/completions", c.BaseURL, c.Port, c.Version)
+	return c.ChatCompletionContext(context.Background(), prompt, messages)
+}
 
+// ChatCompletionContext is ChatCompletion with a context that can cancel the
+// request.
+func (c *OllamaClient) ChatCompletionContext(ctx context.Context, prompt string, messages []Message) (string, error) {
 	requestBody := map[string]interface{}{
 		"model": c.Model,
 		"messages": append(messages, Message{
@@ -64,41 +145,141 @@ func (c *OllamaClient) ChatCompletion(prompt string, messages []Message) (string
 		}),
 	}
 
-	jsonData, err := json.Marshal(requestBody)
+	response, err := c.chatCompletion(ctx, requestBody)
 	if err != nil {
 		return "", err
 	}
 
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	return response.Choices[0].Message.Content, nil
+}
+
+// ChatCompletionStats is ChatCompletionContext reporting the latency and
+// token usage of the reply as well.
+func (c *OllamaClient) ChatCompletionStats(ctx context.Context, prompt string, messages []Message) (*Completion, error) {
+	requestBody := map[string]interface{}{
+		"model": c.Model,
+		"messages": append(messages, Message{
+			Role:    "user",
+			Content: prompt,
+		}),
+	}
+
+	start := time.Now()
+	response, err := c.chatCompletion(ctx, requestBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	request.Header.Set("Content-Type", "application/json")
+	return &Completion{
+		Content:  response.Choices[0].Message.Content,
+		Duration: time.Since(start),
+		Usage:    response.Usage,
+	}, nil
+}
 
-	response, err := http.DefaultClient.Do(request)
+// ChatCompletionWithTools sends messages as they are, offering the model the
+// given tools, and returns the assistant message, which may contain tool calls.
+func (c *OllamaClient) ChatCompletionWithTools(ctx context.Context, messages []Message, tools []Tool) (*Message, error) {
+	requestBody := map[string]interface{}{
+		"model":    c.Model,
+		"messages": messages,
+	}
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
+	}
+
+	response, err := c.chatCompletion(ctx, requestBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	defer response.Body.Close()
+	return &response.Choices[0].Message, nil
+}
 
-	body, err := io.ReadAll(response.Body)
+// ChatCompletionJSON sends messages as they are and asks the model to reply
+// with JSON, constrained to schema when one is given.
+func (c *OllamaClient) ChatCompletionJSON(messages []Message, schema json.RawMessage) (string, error) {
+	responseFormat := map[string]interface{}{"type": "json_object"}
+	if len(schema) > 0 {
+		responseFormat = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": schema,
+			},
+		}
+	}
+
+	requestBody := map[string]interface{}{
+		"model":           c.Model,
+		"messages":        messages,
+		"response_format": responseFormat,
+	}
+
+	response, err := c.chatCompletion(context.Background(), requestBody)
 	if err != nil {
 		return "", err
 	}
 
+	return response.Choices[0].Message.Content, nil
+}
+
+func (c *OllamaClient) chatCompletion(ctx context.Context, requestBody map[string]interface{}) (*OllamaResponse, error) {
+	if c.Options.NumCtx != nil {
+		// The OpenAI compatible endpoint ignores num_ctx
+		return c.chatNative(ctx, requestBody)
+	}
+
+	url := fmt.Sprintf("%s:%s/%s/chat/completions", c.BaseURL, c.Port, c.Version)
+
+	if c.Options.Temperature != nil {
+		requestBody["temperature"] = *c.Options.Temperature
+	}
+	if c.Options.TopP != nil {
+		requestBody["top_p"] = *c.Options.TopP
+	}
+	if c.Options.Seed != nil {
+		requestBody["seed"] = *c.Options.Seed
+	}
+
 	var ollamaResponse OllamaResponse
-	err = json.Unmarshal(body, &ollamaResponse)
-	if err != nil {
-		return "", err
+	if err := c.postChat(ctx, url, requestBody, &ollamaResponse); err != nil {
+		return nil, err
 	}
 
 	if len(ollamaResponse.Choices) == 0 {
-		return "", errors.New("no choices returned")
+		return nil, errors.New("no choices returned")
+	}
+
+	return &ollamaResponse, nil
+}
+
+func (c *OllamaClient) postChat(ctx context.Context, url string, requestBody interface{}, out interface{}) error {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
 	}
 
-	return ollamaResponse.Choices[0].Message.Content, nil
+	return json.Unmarshal(body, out)
 }
 
 func (c *OllamaClient) ListModels() ([]string, error) {
@@ -1,13 +1,38 @@
 package ollamaclient
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	// The supervisor tests run this test binary as a fake "ollama serve"
+	if os.Getenv("TERMPILOT_FAKE_OLLAMA") == "1" {
+		fakeOllama()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func fakeOllama() {
+	host := strings.TrimPrefix(os.Getenv("OLLAMA_HOST"), "http://")
+	fmt.Println("fake ollama listening on", host)
+	http.ListenAndServe(host, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
 func TestOllamaClient(t *testing.T) {
 	// Setup mock server
 	mockServer := setupMockServer()
@@ -23,6 +48,15 @@ func TestOllamaClient(t *testing.T) {
 		assert.Contains(t, response, "I'm doing well")
 	})
 
+	// Test ChatCompletionStats
+	t.Run("ChatCompletionStats", func(t *testing.T) {
+		completion, err := client.ChatCompletionStats(context.Background(), "Hello", []Message{})
+		assert.NoError(t, err)
+		assert.Contains(t, completion.Content, "I'm doing well")
+		assert.Equal(t, 8, completion.Usage.CompletionTokens)
+		assert.Greater(t, completion.Duration, time.Duration(0))
+	})
+
 	// Test ListModels
 	t.Run("ListModels", func(t *testing.T) {
 		models, err := client.ListModels()
@@ -31,6 +65,158 @@ func TestOllamaClient(t *testing.T) {
 		assert.Contains(t, models, "llama3")
 		assert.Contains(t, models, "mistral")
 	})
+
+	// Test ListModelDetails
+	t.Run("ListModelDetails", func(t *testing.T) {
+		models, err := client.ListModelDetails()
+		assert.NoError(t, err)
+		assert.Equal(t, []ModelInfo{
+			{Name: "llama3", Size: 4661224676},
+			{Name: "mistral", Size: 4113301824, Loaded: true},
+		}, models)
+	})
+
+	// Test Embed against the native endpoint
+	t.Run("Embed", func(t *testing.T) {
+		embeddings, err := client.Embed(context.Background(), []string{"first", "second"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(embeddings))
+		assert.Equal(t, []float32{0, 5}, embeddings[0])
+		assert.Equal(t, []float32{1, 6}, embeddings[1])
+	})
+
+	// Test Embed against the OpenAI compatible endpoint, split into batches
+	t.Run("EmbedOpenAIBatched", func(t *testing.T) {
+		batchClient := NewOllamaClient(mockServer.URL, "test-model", "", "v1")
+		batchClient.EmbedAPI = EmbedAPIOpenAI
+		batchClient.EmbedBatchSize = 2
+
+		embeddings, err := batchClient.Embed(context.Background(), []string{"a", "bb", "ccc"})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(embeddings))
+		assert.Equal(t, []float32{1, 2}, embeddings[1])
+		assert.Equal(t, []float32{0, 3}, embeddings[2])
+	})
+
+	// Test Embed with an unknown endpoint
+	t.Run("EmbedUnknownAPI", func(t *testing.T) {
+		badClient := NewOllamaClient(mockServer.URL, "test-model", "", "v1")
+		badClient.EmbedAPI = "bogus"
+
+		_, err := badClient.Embed(context.Background(), []string{"a"})
+		assert.Error(t, err)
+	})
+}
+
+func TestChatOptions(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "ok"}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "test-model", "", "v1")
+
+	_, err := client.ChatCompletion("Hello", []Message{})
+	assert.NoError(t, err)
+	assert.NotContains(t, request, "temperature")
+	assert.NotContains(t, request, "options")
+
+	temperature, topP, seed := 0.5, 0.9, 42
+	client.Options = Options{Temperature: &temperature, TopP: &topP, Seed: &seed}
+
+	_, err = client.ChatCompletion("Hello", []Message{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, request["temperature"])
+	assert.Equal(t, 0.9, request["top_p"])
+	assert.Equal(t, float64(42), request["seed"])
+}
+
+func TestChatNative(t *testing.T) {
+	var path string
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"model": "test-model", "message": {"role": "assistant", "content": "",
+			"tool_calls": [{"function": {"name": "read_file", "arguments": {"path": "go.mod"}}}]},
+			"done_reason": "stop", "prompt_eval_count": 12, "eval_count": 8}`))
+	}))
+	defer server.Close()
+
+	// num_ctx is only read by Ollama's own chat endpoint, from its options
+	client := NewOllamaClient(server.URL, "test-model", "", "v1")
+	temperature, numCtx := 0.5, 8192
+	client.Options = Options{Temperature: &temperature, NumCtx: &numCtx}
+
+	messages := []Message{
+		{Role: "user", Content: "Look", Images: []Image{{MimeType: "image/png", Data: []byte("png")}}},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Function: ToolCallFunction{Name: "list_dir", Arguments: `{"path": "."}`}}}},
+		{Role: "tool", Content: "go.mod", ToolCallID: "call_0"},
+	}
+	reply, err := client.ChatCompletionWithTools(context.Background(), messages, []Tool{{Type: "function", Function: ToolFunction{Name: "read_file"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "/api/chat", path)
+	assert.Equal(t, false, request["stream"])
+	assert.Equal(t, map[string]interface{}{"temperature": 0.5, "num_ctx": float64(8192)}, request["options"])
+	assert.NotContains(t, request, "temperature")
+	assert.Len(t, request["tools"], 1)
+
+	sent := request["messages"].([]interface{})
+	assert.Equal(t, []interface{}{"cG5n"}, sent[0].(map[string]interface{})["images"])
+	call := sent[1].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"path": "."}, call["function"].(map[string]interface{})["arguments"])
+
+	require.Len(t, reply.ToolCalls, 1)
+	assert.Equal(t, "read_file", reply.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"path": "go.mod"}`, reply.ToolCalls[0].Function.Arguments)
+	assert.NotEmpty(t, reply.ToolCalls[0].ID)
+
+	completion, err := client.ChatCompletionStats(context.Background(), "Hello", nil)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{PromptTokens: 12, CompletionTokens: 8, TotalTokens: 20}, completion.Usage)
+
+	_, err = client.ChatCompletionJSON([]Message{{Role: "user", Content: "Hi"}}, json.RawMessage(`{"type": "object"}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"type": "object"}, request["format"])
+	_, err = client.ChatCompletionJSON([]Message{{Role: "user", Content: "Hi"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "json", request["format"])
+}
+
+func TestForward(t *testing.T) {
+	mockServer := setupMockServer()
+	defer mockServer.Close()
+
+	client := NewOllamaClient(mockServer.URL, "test-model", "", "v1")
+
+	response, err := client.Forward(context.Background(), http.MethodPost, "chat/completions", strings.NewReader(`{"model": "llama3"}`))
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var reply OllamaResponse
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&reply))
+	assert.Equal(t, "I'm doing well, thank you for asking!", reply.Choices[0].Message.Content)
+
+	missing, err := client.Forward(context.Background(), http.MethodGet, "missing", nil)
+	assert.NoError(t, err)
+	missing.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+}
+
+func TestMessageImages(t *testing.T) {
+	plain, err := json.Marshal(Message{Role: "user", Content: "Hello"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"role": "user", "content": "Hello"}`, string(plain))
+
+	withImage, err := json.Marshal(Message{Role: "user", Content: "What is this?", Images: []Image{{MimeType: "image/png", Data: []byte("png")}}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"role": "user", "content": [
+		{"type": "text", "text": "What is this?"},
+		{"type": "image_url", "image_url": {"url": "data:image/png;base64,cG5n"}}
+	]}`, string(withImage))
 }
 
 func TestIsOllamaRunning(t *testing.T) {
@@ -53,6 +239,83 @@ func TestIsOllamaRunning(t *testing.T) {
 	assert.False(t, notRunning)
 }
 
+func TestSupervisor(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	t.Setenv("TERMPILOT_FAKE_OLLAMA", "1")
+	supervisor := NewSupervisor("http://127.0.0.1", port, t.TempDir())
+	supervisor.Command = os.Args[0]
+
+	pid, err := supervisor.PID()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pid)
+	assert.ErrorIs(t, supervisor.Stop(), ErrNotStarted)
+
+	assert.NoError(t, supervisor.Start())
+	assert.True(t, supervisor.Running())
+	assert.True(t, supervisor.Started())
+	pid, err = supervisor.PID()
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, pid)
+
+	// Starting again leaves the running server alone
+	assert.NoError(t, supervisor.Start())
+	again, _ := supervisor.PID()
+	assert.Equal(t, pid, again)
+
+	// A PID file whose process is alive but started at another time belongs
+	// to a dead server whose ID was reused, and is not acted on
+	reused := NewSupervisor("http://127.0.0.1", "1", supervisor.Dir)
+	require.NoError(t, os.WriteFile(reused.PIDPath(), []byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), "0")), 0644))
+	pid, err = reused.PID()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pid)
+	assert.NoFileExists(t, reused.PIDPath())
+	require.NoError(t, os.WriteFile(reused.PIDPath(), []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644))
+	assert.ErrorIs(t, reused.Stop(), ErrNotStarted)
+	assert.NoFileExists(t, reused.PIDPath())
+	assert.True(t, supervisor.Running())
+
+	// A supervisor of a later run finds the server through the PID file
+	other := NewSupervisor("http://127.0.0.1", port, supervisor.Dir)
+	assert.NoError(t, other.Stop())
+	assert.False(t, supervisor.Running())
+	assert.NoFileExists(t, supervisor.PIDPath())
+
+	logs, err := os.ReadFile(supervisor.LogPath())
+	assert.NoError(t, err)
+	assert.Contains(t, string(logs), "fake ollama listening on 127.0.0.1:"+port)
+
+	// A server that exits at once points at its logs
+	broken := NewSupervisor("http://127.0.0.1", port, supervisor.Dir)
+	broken.Command = "false"
+	assert.ErrorContains(t, broken.Start(), broken.LogPath())
+
+	remote := NewSupervisor("http://gpu-box", "11434", supervisor.Dir)
+	assert.ErrorContains(t, remote.Start(), "remote host")
+}
+
+func TestStartIfNotRunning(t *testing.T) {
+	supervisor := NewSupervisor("http://localhost", "12345", t.TempDir())
+	supervisor.Command = "false"
+
+	assert.ErrorContains(t, supervisor.StartIfNotRunning(AutoStartNever, nil), "auto_start is never")
+	assert.ErrorContains(t, supervisor.StartIfNotRunning("sometimes", nil), "unknown auto_start")
+
+	// Without a terminal to ask there is no answer to wait for
+	assert.ErrorContains(t, supervisor.StartIfNotRunning(AutoStartAsk, nil), "termpilot ollama start")
+	assert.EqualError(t, supervisor.StartIfNotRunning(AutoStartAsk, func() bool { return false }), "ollama is not running")
+	assert.ErrorContains(t, supervisor.StartIfNotRunning(AutoStartAlways, nil), "ollama exited")
+
+	var out strings.Builder
+	assert.True(t, AskToStartOllama(strings.NewReader("y\n"), &out))
+	assert.Contains(t, out.String(), "(y/n)")
+	assert.False(t, AskToStartOllama(strings.NewReader(""), &out))
+}
+
 // Mock server setup for testing Ollama API
 func setupMockServer() *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -73,7 +336,12 @@ func setupMockServer() *httptest.Server {
 							"content": "I'm doing well, thank you for asking!"
 						}
 					}
-				]
+				],
+				"usage": {
+					"prompt_tokens": 12,
+					"completion_tokens": 8,
+					"total_tokens": 20
+				}
 			}`))
 		case "/v1/models":
 			w.Header().Set("Content-Type", "application/json")
@@ -95,6 +363,52 @@ func setupMockServer() *httptest.Server {
 					}
 				]
 			}`))
+		case "/api/tags":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"models": [{"name": "llama3", "size": 4661224676}, {"name": "mistral", "size": 4113301824}]}`))
+		case "/api/ps":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"models": [{"name": "mistral", "size": 5137025024}]}`))
+		case "/api/embed", "/v1/embeddings":
+			// Each embedding is [position in batch, input length]
+			var request struct {
+				Model string   `json:"model"`
+				Input []string `json:"input"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+
+			embeddings := make([][]float32, len(request.Input))
+			for i, input := range request.Input {
+				embeddings[i] = []float32{float32(i), float32(len(input))}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if r.URL.Path == "/api/embed" {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"model":      request.Model,
+					"embeddings": embeddings,
+				})
+				return
+			}
+
+			// The OpenAI format tags each embedding with its index; reply in
+			// reverse order to check the client sorts them.
+			var data []map[string]interface{}
+			for i := len(embeddings) - 1; i >= 0; i-- {
+				data = append(data, map[string]interface{}{
+					"object":    "embedding",
+					"index":     i,
+					"embedding": embeddings[i],
+				})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"object": "list",
+				"model":  request.Model,
+				"data":   data,
+			})
 		default:
 			w.WriteHeader(http.StatusNotFound)
 		}
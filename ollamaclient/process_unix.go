@@ -0,0 +1,55 @@
+//go:build !windows
+
+package ollamaclient
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// detach puts the server in its own process group, so Ctrl+C in the
+// terminal running termpilot does not reach it.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+func stopProcess(process *os.Process) error {
+	return process.Signal(syscall.SIGTERM)
+}
+
+// processStartTime tells when pid started, from /proc where there is one
+// and from ps otherwise.
+func processStartTime(pid int) (string, error) {
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		// starttime is the 22nd field, counted after the command name in
+		// parentheses as that may hold spaces
+		fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+		if len(fields) < 20 {
+			return "", fmt.Errorf("unexpected /proc/%d/stat", pid)
+		}
+		return fields[19], nil
+	}
+
+	out, err := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", err
+	}
+	startTime := strings.TrimSpace(string(out))
+	if startTime == "" {
+		return "", fmt.Errorf("no process %d", pid)
+	}
+	return startTime, nil
+}
@@ -0,0 +1,47 @@
+//go:build windows
+
+package ollamaclient
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+const processQueryLimitedInformation = 0x1000
+
+// detach starts the server in a new process group, so Ctrl+C in the console
+// running termpilot does not reach it.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
+
+// stopProcess kills the process, Windows has no signal to ask it to exit.
+func stopProcess(process *os.Process) error {
+	return process.Kill()
+}
+
+// processStartTime tells when pid was created.
+func processStartTime(pid int) (string, error) {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(handle)
+
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(creation.Nanoseconds(), 10), nil
+}
@@ -0,0 +1,265 @@
+package ollamaclient
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStartTimeout is how long Start waits for a new server to answer.
+const DefaultStartTimeout = 10 * time.Second
+
+// Policies of StartIfNotRunning for a server that is not running.
+const (
+	AutoStartAsk    = "ask"
+	AutoStartAlways = "always"
+	AutoStartNever  = "never"
+)
+
+// ErrNotStarted is returned by Stop when no server started by a supervisor
+// is running.
+var ErrNotStarted = errors.New("no ollama started by termpilot is running")
+
+// Supervisor starts and stops an Ollama server for an endpoint. The server
+// writes its logs to a file and its process ID and start time to a PID file,
+// both in Dir and named after the port, so later runs can find and stop it.
+type Supervisor struct {
+	BaseURL string
+	Port    string
+	Dir     string
+	// Command is the ollama executable
+	Command      string
+	StartTimeout time.Duration
+
+	// started is set once Start launched a server
+	started bool
+}
+
+func NewSupervisor(baseURL string, port string, dir string) *Supervisor {
+	return &Supervisor{
+		BaseURL:      baseURL,
+		Port:         port,
+		Dir:          dir,
+		Command:      "ollama",
+		StartTimeout: DefaultStartTimeout,
+	}
+}
+
+// Endpoint is the address the server listens on, as OLLAMA_HOST expects it.
+func (s *Supervisor) Endpoint() string {
+	if s.Port == "" {
+		return s.BaseURL
+	}
+	return s.BaseURL + ":" + s.Port
+}
+
+func (s *Supervisor) LogPath() string {
+	return filepath.Join(s.Dir, "ollama-"+s.name()+".log")
+}
+
+func (s *Supervisor) PIDPath() string {
+	return filepath.Join(s.Dir, "ollama-"+s.name()+".pid")
+}
+
+func (s *Supervisor) name() string {
+	if s.Port != "" {
+		return s.Port
+	}
+	if u, err := url.Parse(s.BaseURL); err == nil && u.Port() != "" {
+		return u.Port()
+	}
+	return "default"
+}
+
+// Running reports whether a server answers at the endpoint, whoever started it.
+func (s *Supervisor) Running() bool {
+	return IsOllamaRunning(s.BaseURL, s.Port)
+}
+
+// Started reports whether this supervisor launched the running server.
+func (s *Supervisor) Started() bool {
+	return s.started
+}
+
+// PID returns the process ID of the server a supervisor started, or 0 if
+// that process is gone. A stale PID file is removed.
+func (s *Supervisor) PID() (int, error) {
+	pid, startTime, err := s.readPIDFile()
+	if err != nil {
+		return 0, err
+	}
+	if !isProcess(pid, startTime) {
+		os.Remove(s.PIDPath())
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// readPIDFile returns the process ID and start time in the PID file, or 0
+// when there is none or it cannot be read as such.
+func (s *Supervisor) readPIDFile() (int, string, error) {
+	data, err := os.ReadFile(s.PIDPath())
+	if os.IsNotExist(err) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	if len(fields) != 2 {
+		return 0, "", nil
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", nil
+	}
+	return pid, fields[1], nil
+}
+
+// isProcess reports whether pid still is the process that started at
+// startTime, rather than a later one reusing its ID.
+func isProcess(pid int, startTime string) bool {
+	if pid <= 0 || startTime == "" || !processAlive(pid) {
+		return false
+	}
+	current, err := processStartTime(pid)
+	return err == nil && current == startTime
+}
+
+// Start runs "ollama serve" on the endpoint unless a server already answers
+// there, and waits until it does. The server outlives termpilot until Stop
+// is called.
+func (s *Supervisor) Start() error {
+	if s.Running() {
+		return nil
+	}
+	if !s.local() {
+		return fmt.Errorf("cannot start ollama on the remote host of %s", s.BaseURL)
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(s.LogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(s.Command, "serve")
+	cmd.Env = append(os.Environ(), "OLLAMA_HOST="+s.Endpoint())
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start Ollama: %v", err)
+	}
+	startTime, err := processStartTime(cmd.Process.Pid)
+	if err == nil {
+		err = os.WriteFile(s.PIDPath(), []byte(fmt.Sprintf("%d\n%s\n", cmd.Process.Pid, startTime)), 0644)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+
+	// Reap the process when it exits, whether or not it came up
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(s.StartTimeout)
+	for {
+		if s.Running() {
+			s.started = true
+			return nil
+		}
+		select {
+		case err := <-exited:
+			os.Remove(s.PIDPath())
+			return fmt.Errorf("ollama exited (%v), see %s", err, s.LogPath())
+		case <-deadline:
+			return fmt.Errorf("ollama failed to start after %s, see %s", s.StartTimeout, s.LogPath())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// StartIfNotRunning starts the server when none answers at the endpoint,
+// following policy. AutoStartAsk calls ask, which is nil when there is no one
+// to answer, so the server is not started then.
+func (s *Supervisor) StartIfNotRunning(policy string, ask func() bool) error {
+	if s.Running() {
+		return nil
+	}
+
+	switch policy {
+	case AutoStartAlways:
+	case AutoStartNever:
+		return fmt.Errorf("ollama is not running at %s and auto_start is never", s.Endpoint())
+	case AutoStartAsk, "":
+		if ask == nil {
+			return fmt.Errorf("ollama is not running at %s, start it with \"termpilot ollama start\" or set auto_start to always", s.Endpoint())
+		}
+		if !ask() {
+			return fmt.Errorf("ollama is not running")
+		}
+	default:
+		return fmt.Errorf("unknown auto_start value %q, use always, never or ask", policy)
+	}
+	return s.Start()
+}
+
+// Stop shuts down the server a supervisor started, asking it to exit first
+// and killing it if it has not after a few seconds. Processes are only
+// signalled while their start time matches the PID file, so a process that
+// reused the ID of a dead server is left alone.
+func (s *Supervisor) Stop() error {
+	pid, startTime, err := s.readPIDFile()
+	if err != nil {
+		return err
+	}
+	if !isProcess(pid, startTime) {
+		os.Remove(s.PIDPath())
+		return ErrNotStarted
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := stopProcess(process); err != nil {
+		return err
+	}
+	for i := 0; i < 50 && isProcess(pid, startTime); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if isProcess(pid, startTime) {
+		if err := process.Kill(); err != nil {
+			return err
+		}
+	}
+
+	s.started = false
+	return os.Remove(s.PIDPath())
+}
+
+// local reports whether the endpoint is on this machine.
+func (s *Supervisor) local() bool {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return false
+	}
+	switch u.Hostname() {
+	case "", "localhost", "127.0.0.1", "::1", "0.0.0.0":
+		return true
+	}
+	return false
+}
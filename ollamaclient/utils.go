@@ -1,9 +1,11 @@
 package ollamaclient
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net/http"
-	"os/exec"
+	"strings"
 	"time"
 )
 
@@ -22,36 +24,11 @@ func IsOllamaRunning(baseURL string, port string) bool {
 	return resp.StatusCode == http.StatusOK
 }
 
-func StartOllama() error {
-	cmd := exec.Command("ollama", "serve")
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start Ollama: %v", err)
-	}
-
-	for i := 0; i < 10; i++ {
-		if IsOllamaRunning("http://localhost", "11434") {
-			return nil
-		}
-		time.Sleep(1 * time.Second)
-	}
-
-	return fmt.Errorf("ollama failed to start after 10 seconds")
-}
-
-func AskToStartOllama() bool {
-	fmt.Print("Ollama is not running. Would you like to start it? (y/n): ")
-	var response string
-	fmt.Scanln(&response)
+// AskToStartOllama asks on out whether to start Ollama and reads the answer
+// from in.
+func AskToStartOllama(in io.Reader, out io.Writer) bool {
+	fmt.Fprint(out, "Ollama is not running. Would you like to start it? (y/n): ")
+	response, _ := bufio.NewReader(in).ReadString('\n')
+	response = strings.TrimSpace(response)
 	return response == "y" || response == "Y"
 }
-
-func StartOllamaIfNotRunning() error {
-	if !IsOllamaRunning("http://localhost", "11434") {
-		if AskToStartOllama() {
-			return StartOllama()
-		}
-		return fmt.Errorf("ollama is not running")
-	}
-	return nil
-}
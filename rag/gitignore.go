@@ -0,0 +1,127 @@
+package rag
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type ignoreRule struct {
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+type ignoreList []ignoreRule
+
+// loadGitignore reads the .gitignore in dir (if any). base is the directory
+// relative to the walk root, using forward slashes.
+func loadGitignore(dir string, base string) (ignoreList, error) {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules ignoreList
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(base, scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func parseIgnoreRule(base string, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, "\\")
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.HasPrefix(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// ignored reports whether rel (slash separated, relative to the walk root)
+// is excluded. As in git, the last matching rule wins.
+func (l ignoreList) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range l {
+		if rule.matches(rel, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	sub := rel
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		sub = strings.TrimPrefix(rel, r.base+"/")
+	}
+
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, path.Base(sub))
+		return ok
+	}
+	return globMatch(strings.Split(r.pattern, "/"), strings.Split(sub, "/"))
+}
+
+// globMatch matches path segments against pattern segments, where a "**"
+// segment matches zero or more path segments.
+func globMatch(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(segments); i++ {
+				if globMatch(rest, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,115 @@
+package rag
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"termpilot/models"
+)
+
+const (
+	DefaultChunkLines   = 40
+	DefaultChunkOverlap = 10
+	maxFileSize         = 512 * 1024
+)
+
+// CollectFiles walks root and returns the text files that are not excluded by
+// a .gitignore, as paths relative to root.
+func CollectFiles(root string) ([]string, error) {
+	var rules ignoreList
+	var files []string
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == "." {
+				rel = ""
+			} else if d.Name() == ".git" || rules.ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			dirRules, err := loadGitignore(p, rel)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, dirRules...)
+			return nil
+		}
+
+		if !d.Type().IsRegular() || rules.ignored(rel, false) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 || info.Size() > maxFileSize {
+			return nil
+		}
+
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ChunkFile reads root/rel and splits it into overlapping chunks of lines.
+// Binary files yield no chunks.
+func ChunkFile(root string, rel string, size int, overlap int) ([]models.Chunk, error) {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, err
+	}
+	if isBinary(data) {
+		return nil, nil
+	}
+	return ChunkText(rel, string(data), size, overlap), nil
+}
+
+func ChunkText(source string, text string, size int, overlap int) []models.Chunk {
+	if size <= 0 {
+		size = DefaultChunkLines
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+
+	var chunks []models.Chunk
+	for start := 0; start < len(lines); start += size - overlap {
+		end := min(start+size, len(lines))
+		content := strings.Join(lines[start:end], "\n")
+		if strings.TrimSpace(content) != "" {
+			chunks = append(chunks, models.Chunk{
+				Source:    source,
+				StartLine: start + 1,
+				EndLine:   end,
+				Content:   content,
+			})
+		}
+		if end == len(lines) {
+			break
+		}
+	}
+	return chunks
+}
+
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1
+}
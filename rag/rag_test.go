@@ -0,0 +1,92 @@
+package rag
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"termpilot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectFiles(t *testing.T) {
+	root := t.TempDir()
+
+	// Build a small tree with nested .gitignore files
+	files := map[string]string{
+		".gitignore":         "*.log\nbuild/\n/secret.txt\n",
+		"main.go":            "package main\n",
+		"debug.log":          "ignored\n",
+		"secret.txt":         "ignored\n",
+		"build/out.go":       "ignored\n",
+		"pkg/.gitignore":     "generated/**\n!keep.log\n",
+		"pkg/lib.go":         "package pkg\n",
+		"pkg/keep.log":       "kept by negation\n",
+		"pkg/secret.txt":     "only anchored at the root\n",
+		"pkg/generated/a.go": "ignored\n",
+		".git/config":        "ignored\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(root, "image.bin"), []byte{0x89, 0x00, 0x01}, 0644))
+
+	collected, err := CollectFiles(root)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		".gitignore",
+		"main.go",
+		"image.bin",
+		"pkg/.gitignore",
+		"pkg/lib.go",
+		"pkg/keep.log",
+		"pkg/secret.txt",
+	}, collected)
+
+	// Binary files produce no chunks
+	chunks, err := ChunkFile(root, "image.bin", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, chunks)
+}
+
+func TestChunkText(t *testing.T) {
+	text := "1\n2\n3\n4\n5\n6\n7\n"
+
+	chunks := ChunkText("file.txt", text, 3, 1)
+	assert.Equal(t, 3, len(chunks))
+	assert.Equal(t, "1\n2\n3", chunks[0].Content)
+	assert.Equal(t, 3, chunks[1].StartLine)
+	assert.Equal(t, 5, chunks[1].EndLine)
+	assert.Equal(t, "5\n6\n7", chunks[2].Content)
+	assert.Equal(t, "file.txt:5-7", Citation(chunks[2]))
+}
+
+func TestSearch(t *testing.T) {
+	vector := []float32{0.25, -1, 3.5}
+	assert.Equal(t, vector, DecodeVector(EncodeVector(vector)))
+
+	assert.InDelta(t, 1.0, CosineSimilarity([]float32{1, 2}, []float32{2, 4}), 1e-9)
+	assert.InDelta(t, 0.0, CosineSimilarity([]float32{1, 0}, []float32{0, 1}), 1e-9)
+	assert.Equal(t, 0.0, CosineSimilarity([]float32{1}, []float32{1, 2}))
+
+	chunks := []models.Chunk{
+		{Source: "a.go", StartLine: 1, EndLine: 2, Content: "alpha", Embedding: EncodeVector([]float32{1, 0})},
+		{Source: "b.go", StartLine: 1, EndLine: 2, Content: "beta", Embedding: EncodeVector([]float32{0, 1})},
+		{Source: "c.go", StartLine: 1, EndLine: 2, Content: "gamma", Embedding: EncodeVector([]float32{1, 1})},
+	}
+
+	results := Search([]float32{0.9, 0.1}, chunks, 2)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "a.go", results[0].Chunk.Source)
+	assert.Equal(t, "c.go", results[1].Chunk.Source)
+
+	prompt := BuildPrompt("What is alpha?", results)
+	assert.Contains(t, prompt, "[1] a.go:1-2")
+	assert.Contains(t, prompt, "[2] c.go:1-2")
+	assert.Contains(t, prompt, "Question: What is alpha?")
+	assert.Equal(t, "plain", BuildPrompt("plain", nil))
+}
@@ -0,0 +1,93 @@
+package rag
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"termpilot/models"
+)
+
+const DefaultTopK = 5
+
+type Result struct {
+	Chunk models.Chunk
+	Score float64
+}
+
+func EncodeVector(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(v))
+	}
+	return buf
+}
+
+func DecodeVector(buf []byte) []float32 {
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+	}
+	return vector
+}
+
+func CosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Search returns the k chunks most similar to query, best first.
+func Search(query []float32, chunks []models.Chunk, k int) []Result {
+	results := make([]Result, 0, len(chunks))
+	for _, chunk := range chunks {
+		results = append(results, Result{
+			Chunk: chunk,
+			Score: CosineSimilarity(query, DecodeVector(chunk.Embedding)),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// BuildPrompt prepends the retrieved chunks to prompt, numbered so the model
+// can cite them.
+func BuildPrompt(prompt string, results []Result) string {
+	if len(results) == 0 {
+		return prompt
+	}
+
+	var b strings.Builder
+	b.WriteString("Answer using the following excerpts from local files where relevant. ")
+	b.WriteString("Cite the excerpts you use as [n] and list their sources at the end.\n\n")
+	for i, result := range results {
+		fmt.Fprintf(&b, "[%d] %s\n```\n%s\n```\n\n", i+1, Citation(result.Chunk), result.Chunk.Content)
+	}
+	b.WriteString("Question: ")
+	b.WriteString(prompt)
+	return b.String()
+}
+
+func Citation(chunk models.Chunk) string {
+	return fmt.Sprintf("%s:%d-%d", chunk.Source, chunk.StartLine, chunk.EndLine)
+}
@@ -0,0 +1,59 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+type Result struct {
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Shell returns the user's shell from $SHELL, defaulting to sh.
+func Shell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "sh"
+}
+
+// Run executes command with the user's shell, capturing its output while also
+// copying it to stdout and stderr when they are not nil. A non-zero exit code
+// is reported in the result rather than as an error.
+func Run(ctx context.Context, command string, stdout io.Writer, stderr io.Writer) (*Result, error) {
+	var outBuf, errBuf bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, Shell(), "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = teeWriter(&outBuf, stdout)
+	cmd.Stderr = teeWriter(&errBuf, stderr)
+
+	result := &Result{Command: command}
+	err := cmd.Run()
+	result.Stdout = outBuf.String()
+	result.Stderr = errBuf.String()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func teeWriter(buf *bytes.Buffer, w io.Writer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, w)
+}
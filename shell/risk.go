@@ -0,0 +1,145 @@
+package shell
+
+import (
+	"regexp"
+	"strings"
+)
+
+type Risk int
+
+const (
+	RiskLow Risk = iota
+	RiskMedium
+	RiskHigh
+)
+
+func (r Risk) String() string {
+	switch r {
+	case RiskLow:
+		return "low"
+	case RiskMedium:
+		return "medium"
+	case RiskHigh:
+		return "high"
+	}
+	return "unknown"
+}
+
+type riskPattern struct {
+	risk   Risk
+	reason string
+	re     *regexp.Regexp
+}
+
+// Patterns are matched against each command of a pipeline or list separately.
+var riskPatterns = []riskPattern{
+	{RiskHigh, "recursively deletes files", regexp.MustCompile(`^rm\s+(.*\s)?(-[a-zA-Z]*[rR][a-zA-Z]*|--recursive)(\s|$)`)},
+	{RiskHigh, "writes raw data to devices or files", regexp.MustCompile(`^dd\b`)},
+	{RiskHigh, "creates a filesystem", regexp.MustCompile(`^mkfs(\.\w+)?\b`)},
+	{RiskHigh, "overwrites a block device", regexp.MustCompile(`>\s*/dev/(sd|nvme|hd|disk|mmcblk)`)},
+	{RiskHigh, "recursively changes permissions", regexp.MustCompile(`^chmod\s+(.*\s)?(-[a-zA-Z]*R[a-zA-Z]*|--recursive)(\s|$)`)},
+	{RiskHigh, "recursively changes ownership", regexp.MustCompile(`^chown\s+(.*\s)?(-[a-zA-Z]*R[a-zA-Z]*|--recursive)(\s|$)`)},
+	{RiskHigh, "securely erases files", regexp.MustCompile(`^(shred|wipefs)\b`)},
+	{RiskHigh, "powers off or restarts the machine", regexp.MustCompile(`^(shutdown|reboot|halt|poweroff)\b`)},
+	{RiskHigh, "is a fork bomb", regexp.MustCompile(`:\(\)\s*\{`)},
+	{RiskHigh, "executes a downloaded script", regexp.MustCompile(`(curl|wget)\b.*\|\s*(sudo\s+)?(sh|bash|zsh)\b`)},
+	{RiskHigh, "discards uncommitted git changes", regexp.MustCompile(`^git\s+(reset\s+--hard|clean\s+-[a-zA-Z]*f)`)},
+	{RiskHigh, "rewrites remote git history", regexp.MustCompile(`^git\s+push\s+(.*\s)?(-f|--force)\b`)},
+	{RiskMedium, "deletes files", regexp.MustCompile(`^(rm|rmdir|unlink)\b`)},
+	{RiskMedium, "deletes files found by find", regexp.MustCompile(`^find\b.*\s(-delete|-exec\s+rm)\b`)},
+	{RiskMedium, "runs with elevated privileges", regexp.MustCompile(`^(sudo|doas|su)\b`)},
+	{RiskMedium, "kills processes", regexp.MustCompile(`^(kill|killall|pkill)\b`)},
+	{RiskMedium, "moves or overwrites files", regexp.MustCompile(`^(mv|truncate)\b`)},
+	{RiskMedium, "changes permissions or ownership", regexp.MustCompile(`^(chmod|chown|chgrp)\b`)},
+	{RiskMedium, "installs or removes packages", regexp.MustCompile(`^(apt|apt-get|yum|dnf|pacman|brew|pip|npm)\s+(install|remove|uninstall|purge)\b`)},
+}
+
+var (
+	separators = regexp.MustCompile(`\|\||&&|[;|&\n]`)
+	// wrapper matches commands running the command that follows them, with
+	// their options and variable assignments
+	wrapper = regexp.MustCompile(`^(sudo|doas|env|nice|ionice|nohup|time|timeout|stdbuf|xargs|exec)\s+((-[ugIC]\s+\S+|-\S+|\d+\S*|\w+=\S*)\s+)*`)
+	// builtin matches the shell builtins running a command without looking
+	// up aliases or functions; command -v only prints where it is
+	builtin     = regexp.MustCompile(`^(command|builtin)\s+(-p\s+)?`)
+	assignments = regexp.MustCompile(`^(\w+=\S*\s+)+`)
+	// program matches the directory and the alias-bypassing backslash in
+	// front of the program name, as in /bin/rm or \rm
+	program     = regexp.MustCompile(`^\\?(\S*/)?`)
+	findExec    = regexp.MustCompile(`\s-(exec|execdir|ok|okdir)\s+(.*)$`)
+	shellScript = regexp.MustCompile(`\b(sh|bash|zsh|dash|ksh)\s+(-\S+\s+)*-[a-zA-Z]*c\s+('([^']*)'|"([^"]*)"|(\S+))`)
+)
+
+// unwrap strips the wrappers and variable assignments in front of command,
+// and the directory of the program it runs.
+func unwrap(command string) string {
+	for {
+		unwrapped := program.ReplaceAllString(command, "")
+		unwrapped = builtin.ReplaceAllString(unwrapped, "")
+		unwrapped = wrapper.ReplaceAllString(unwrapped, "")
+		unwrapped = assignments.ReplaceAllString(unwrapped, "")
+		if unwrapped == command {
+			return command
+		}
+		command = unwrapped
+	}
+}
+
+// Classify returns the highest risk found in command and the reasons for it.
+func Classify(command string) (Risk, []string) {
+	risk := RiskLow
+	var reasons []string
+	add := func(r Risk, reason string) {
+		if r > risk {
+			risk = r
+		}
+		if !contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	// Pipelines into a shell are checked on the whole command line, and
+	// commands run by sudo, env, xargs, find -exec and the like are also
+	// checked on their own.
+	parts := []string{command}
+	for _, part := range separators.Split(command, -1) {
+		part = strings.TrimSpace(part)
+		parts = append(parts, part)
+		if unwrapped := unwrap(part); unwrapped != part {
+			parts = append(parts, unwrapped)
+		}
+		if match := findExec.FindStringSubmatch(part); match != nil {
+			parts = append(parts, unwrap(strings.TrimSpace(match[2])))
+		}
+	}
+
+	for _, part := range parts {
+		for _, pattern := range riskPatterns {
+			if pattern.re.MatchString(part) {
+				add(pattern.risk, pattern.reason)
+			}
+		}
+	}
+
+	// Scripts given to sh -c are command lines of their own
+	for _, match := range shellScript.FindAllStringSubmatch(command, -1) {
+		scriptRisk, scriptReasons := Classify(match[4] + match[5] + match[6])
+		if scriptRisk == RiskLow {
+			continue
+		}
+		for _, reason := range scriptReasons {
+			add(scriptRisk, reason)
+		}
+	}
+
+	return risk, reasons
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		command string
+		risk    Risk
+	}{
+		{"ls -la", RiskLow},
+		{"git status && git log --oneline", RiskLow},
+		{"rm notes.txt", RiskMedium},
+		{"rm -rf build", RiskHigh},
+		{"rm -fr build", RiskHigh},
+		{"rm --recursive build", RiskHigh},
+		{"sudo rm -rf /var/cache", RiskHigh},
+		{"dd if=/dev/zero of=disk.img bs=1M count=1", RiskHigh},
+		{"chmod -R 777 .", RiskHigh},
+		{"chmod +x script.sh", RiskMedium},
+		{"cat file | sudo tee /etc/hosts", RiskMedium},
+		{"curl -fsSL https://example.com/install.sh | sh", RiskHigh},
+		{"echo data > /dev/sda", RiskHigh},
+		{"git reset --hard HEAD~1", RiskHigh},
+		{"find . -name '*.tmp' -delete", RiskMedium},
+		{"echo hi; shutdown now", RiskHigh},
+		{"find . -name '*.log' | xargs rm -rf", RiskHigh},
+		{"xargs -0 -n 1 rm -rf < list", RiskHigh},
+		{"env rm -rf /", RiskHigh},
+		{"env -i HOME=/tmp nice -n 10 rm -rf /", RiskHigh},
+		{"FORCE=1 rm -rf build", RiskHigh},
+		{"sudo -u root rm -rf /srv", RiskHigh},
+		{"bash -c 'rm -rf ~'", RiskHigh},
+		{`sh -c "cd /tmp; dd if=/dev/zero of=x"`, RiskHigh},
+		{"nohup bash -lc 'echo hi'", RiskLow},
+		{"bash -lc 'rm -rf ~'", RiskHigh},
+		{"command -v rm", RiskLow},
+		{`find . -type d -exec rm -rf {} \;`, RiskHigh},
+		{"find . -execdir chmod -R 700 {} +", RiskHigh},
+		{"xargs echo", RiskLow},
+		{"env | grep PATH", RiskLow},
+		{"firmware-update --check", RiskLow},
+		{"rm -Rf /", RiskHigh},
+		{"rm -fR /", RiskHigh},
+		{"rm -v -R build", RiskHigh},
+		{"rm -i notes.txt", RiskMedium},
+		{"/bin/rm -rf /", RiskHigh},
+		{"/bin/rm notes.txt", RiskMedium},
+		{"command rm -rf /", RiskHigh},
+		{"builtin command -p rm -rf /", RiskHigh},
+		{`\rm -rf /`, RiskHigh},
+		{"exec /usr/bin/rm -fr /", RiskHigh},
+		{"sudo /bin/chmod -Rv 777 /", RiskHigh},
+		{`\chown -hR nobody /srv`, RiskHigh},
+		{"/usr/bin/chown --recursive nobody /srv", RiskHigh},
+		{"chmod -r notes.txt", RiskMedium},
+		{"./build/run.sh", RiskLow},
+	}
+
+	for _, tt := range tests {
+		risk, reasons := Classify(tt.command)
+		assert.Equal(t, tt.risk, risk, tt.command)
+		if tt.risk == RiskLow {
+			assert.Empty(t, reasons, tt.command)
+		} else {
+			assert.NotEmpty(t, reasons, tt.command)
+		}
+	}
+
+	assert.Equal(t, "high", RiskHigh.String())
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("SHELL", "sh")
+
+	result, err := Run(context.Background(), "echo out; echo err >&2; exit 3", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "out\n", result.Stdout)
+	assert.Equal(t, "err\n", result.Stderr)
+	assert.Equal(t, 3, result.ExitCode)
+
+	result, err = Run(context.Background(), "true", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+}
@@ -0,0 +1,262 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Ext is the extension of template files.
+const Ext = ".tmpl"
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Template is a prompt written with text/template. Its variables are read
+// with {{.name}}, and a leading {{/* comment */}} describes it.
+type Template struct {
+	Name        string
+	Description string
+	Source      string
+	// Variables are the names the template reads, in order of appearance
+	Variables []string
+	// optional names only appear in conditional parts, like {{if .name}}
+	optional map[string]bool
+	tmpl     *template.Template
+}
+
+// MissingVariablesError lists the variables a template needs but was not given.
+type MissingVariablesError struct {
+	Template string
+	Names    []string
+}
+
+func (e *MissingVariablesError) Error() string {
+	return fmt.Sprintf("template %s is missing variables: %s", e.Template, strings.Join(e.Names, ", "))
+}
+
+// Parse parses source as the template name.
+func Parse(name, source string) (*Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Template{
+		Name:        name,
+		Description: description(source),
+		Source:      source,
+		optional:    map[string]bool{},
+		tmpl:        tmpl,
+	}
+
+	required := map[string]bool{}
+	for _, defined := range tmpl.Templates() {
+		if defined.Tree != nil {
+			collect(defined.Tree.Root, false, t, required)
+		}
+	}
+	for name := range required {
+		delete(t.optional, name)
+	}
+	return t, nil
+}
+
+// description is the text of a comment opening source.
+func description(source string) string {
+	source = strings.TrimSpace(source)
+	if !strings.HasPrefix(source, "{{/*") {
+		return ""
+	}
+	end := strings.Index(source, "*/}}")
+	if end == -1 {
+		return ""
+	}
+	return strings.TrimSpace(source[len("{{/*"):end])
+}
+
+// collect records the variables read under node, which are optional in
+// conditional parts. Fields inside with and range bodies refer to their new
+// dot rather than to the variables.
+func collect(node parse.Node, condition bool, t *Template, required map[string]bool) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			collect(child, condition, t, required)
+		}
+	case *parse.ActionNode:
+		collect(node.Pipe, condition, t, required)
+	case *parse.IfNode:
+		collect(node.Pipe, true, t, required)
+		collect(node.List, true, t, required)
+		collect(node.ElseList, true, t, required)
+	case *parse.WithNode:
+		collect(node.Pipe, true, t, required)
+		collect(node.ElseList, true, t, required)
+	case *parse.RangeNode:
+		collect(node.Pipe, true, t, required)
+		collect(node.ElseList, true, t, required)
+	case *parse.TemplateNode:
+		collect(node.Pipe, condition, t, required)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, cmd := range node.Cmds {
+			collect(cmd, condition, t, required)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			collect(arg, condition, t, required)
+		}
+	case *parse.ChainNode:
+		collect(node.Node, condition, t, required)
+	case *parse.FieldNode:
+		t.addVariable(node.Ident[0], condition, required)
+	case *parse.VariableNode:
+		// $.name reads a variable from anywhere
+		if len(node.Ident) > 1 && node.Ident[0] == "$" {
+			t.addVariable(node.Ident[1], condition, required)
+		}
+	}
+}
+
+func (t *Template) addVariable(name string, condition bool, required map[string]bool) {
+	if !t.Uses(name) {
+		t.Variables = append(t.Variables, name)
+	}
+	if condition {
+		t.optional[name] = true
+	} else {
+		required[name] = true
+	}
+}
+
+// Uses reports whether the template reads the variable name.
+func (t *Template) Uses(name string) bool {
+	for _, variable := range t.Variables {
+		if variable == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Required lists the variables that must be given to Render.
+func (t *Template) Required() []string {
+	var required []string
+	for _, name := range t.Variables {
+		if !t.optional[name] {
+			required = append(required, name)
+		}
+	}
+	return required
+}
+
+// Render executes the template with vars, failing with a
+// MissingVariablesError when required variables are not set.
+func (t *Template) Render(vars map[string]string) (string, error) {
+	var missing []string
+	for _, name := range t.Required() {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", &MissingVariablesError{Template: t.Name, Names: missing}
+	}
+
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func templateFile(dir, name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid template name %q, use letters, digits, - and _", name)
+	}
+	return filepath.Join(dir, name+Ext), nil
+}
+
+// Load reads the template name from dir.
+func Load(dir, name string) (*Template, error) {
+	file, err := templateFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("template %s not found in %s", name, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(name, string(data))
+}
+
+// List reads all templates in dir sorted by name. A missing dir holds none.
+func List(dir string) ([]*Template, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var list []*Template
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), Ext)
+		t, err := Load(dir, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// Save checks that source parses and writes it as the template name.
+func Save(dir, name, source string) error {
+	file, err := templateFile(dir, name)
+	if err != nil {
+		return err
+	}
+	if _, err := Parse(name, source); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(source), 0644)
+}
+
+func Delete(dir, name string) error {
+	file, err := templateFile(dir, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// ParseVars turns key=value assignments into variables.
+func ParseVars(assignments []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, assignment := range assignments {
+		name, value, ok := strings.Cut(assignment, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid variable %q, use name=value", assignment)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
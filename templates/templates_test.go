@@ -0,0 +1,85 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVariables(t *testing.T) {
+	tmpl, err := Parse("review", `{{/* Review code in a language */}}
+Review this {{.lang}} code{{if .focus}}, focusing on {{.focus}}{{end}}:
+{{range .files}}{{.}}{{end}}
+{{with .style}}{{.Ignored}}{{end}}{{$.lang}}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Review code in a language", tmpl.Description)
+	assert.Equal(t, []string{"lang", "focus", "files", "style"}, tmpl.Variables)
+	assert.Equal(t, []string{"lang"}, tmpl.Required())
+	assert.True(t, tmpl.Uses("focus"))
+	assert.False(t, tmpl.Uses("Ignored"))
+
+	_, err = Parse("broken", "{{.lang")
+	assert.Error(t, err)
+}
+
+func TestRender(t *testing.T) {
+	tmpl, err := Parse("tests", "Write {{.framework}} tests for {{.target}}{{if .edge}} with edge cases{{end}}.")
+	require.NoError(t, err)
+
+	_, err = tmpl.Render(map[string]string{"framework": "testify"})
+	var missing *MissingVariablesError
+	require.ErrorAs(t, err, &missing)
+	assert.Equal(t, []string{"target"}, missing.Names)
+	assert.EqualError(t, err, "template tests is missing variables: target")
+
+	out, err := tmpl.Render(map[string]string{"framework": "testify", "target": "Parse"})
+	require.NoError(t, err)
+	assert.Equal(t, "Write testify tests for Parse.", out)
+
+	out, err = tmpl.Render(map[string]string{"framework": "testify", "target": "Parse", "edge": "yes"})
+	require.NoError(t, err)
+	assert.Equal(t, "Write testify tests for Parse with edge cases.", out)
+}
+
+func TestStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "templates")
+
+	list, err := List(dir)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	require.NoError(t, Save(dir, "review", "Review {{.lang}}"))
+	require.NoError(t, Save(dir, "explain", "Explain {{.topic}}"))
+	assert.Error(t, Save(dir, "broken", "{{.lang"))
+	assert.Error(t, Save(dir, "../escape", "text"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a template"), 0644))
+
+	list, err = List(dir)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "explain", list[0].Name)
+	assert.Equal(t, "review", list[1].Name)
+
+	tmpl, err := Load(dir, "review")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lang"}, tmpl.Variables)
+
+	require.NoError(t, Delete(dir, "review"))
+	_, err = Load(dir, "review")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestParseVars(t *testing.T) {
+	vars, err := ParseVars([]string{"lang=go", "note=a=b", "empty="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"lang": "go", "note": "a=b", "empty": ""}, vars)
+
+	_, err = ParseVars([]string{"lang"})
+	assert.Error(t, err)
+	_, err = ParseVars([]string{"=go"})
+	assert.Error(t, err)
+}
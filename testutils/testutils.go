@@ -1,9 +1,11 @@
 package testutils
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"termpilot/models"
 	"termpilot/ollamaclient"
 	"time"
@@ -20,7 +22,7 @@ func SetupTestDB(path string) (*gorm.DB, error) {
 	}
 
 	// Migrate models
-	db.AutoMigrate(&models.Conversation{}, &models.Message{})
+	db.AutoMigrate(&models.Conversation{}, &models.Message{}, &models.Index{}, &models.Chunk{}, &models.Draft{}, &models.Comparison{}, &models.Candidate{}, &models.Image{}, &models.Blob{}, &models.Attachment{})
 
 	return db, nil
 }
@@ -83,7 +85,25 @@ func MockOllamaServer() *httptest.Server {
 							"content": "I'm a test response"
 						}
 					}
-				]
+				],
+				"usage": {
+					"prompt_tokens": 12,
+					"completion_tokens": 8,
+					"total_tokens": 20
+				}
+			}`))
+		case "/api/chat":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{
+				"model": "test-model",
+				"message": {
+					"role": "assistant",
+					"content": "I'm a test response"
+				},
+				"done_reason": "stop",
+				"prompt_eval_count": 12,
+				"eval_count": 8
 			}`))
 		case "/v1/models":
 			w.Header().Set("Content-Type", "application/json")
@@ -99,12 +119,61 @@ func MockOllamaServer() *httptest.Server {
 					}
 				]
 			}`))
+		case "/api/tags":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"models": [{"name": "test-model", "size": 1000000000}, {"name": "other-model", "size": 2000000000}]}`))
+		case "/api/ps":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"models": [{"name": "test-model", "size": 1200000000}]}`))
+		case "/api/embed", "/v1/embeddings":
+			var request struct {
+				Model string   `json:"model"`
+				Input []string `json:"input"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+
+			// Deterministic embeddings derived from each input
+			embeddings := make([][]float32, len(request.Input))
+			for i, input := range request.Input {
+				embeddings[i] = MockEmbedding(input)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if r.URL.Path == "/api/embed" {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"model":      request.Model,
+					"embeddings": embeddings,
+				})
+				return
+			}
+
+			data := make([]map[string]interface{}, len(embeddings))
+			for i, embedding := range embeddings {
+				data[i] = map[string]interface{}{
+					"object":    "embedding",
+					"index":     i,
+					"embedding": embedding,
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"object": "list",
+				"model":  request.Model,
+				"data":   data,
+			})
 		default:
 			w.WriteHeader(http.StatusOK)
 		}
 	}))
 }
 
+// MockEmbedding returns the embedding MockOllamaServer computes for input
+func MockEmbedding(input string) []float32 {
+	return []float32{float32(len(input)), float32(strings.Count(input, " ")), 1}
+}
+
 // NewTestOllamaClient creates an Ollama client for testing
 func NewTestOllamaClient(server *httptest.Server) *ollamaclient.OllamaClient {
 	return ollamaclient.NewOllamaClient(
@@ -0,0 +1,251 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"termpilot/rag"
+)
+
+const (
+	maxOutputBytes = 64 * 1024
+	maxGrepMatches = 100
+)
+
+// NewBuiltinRegistry returns a registry with the read-only built-in tools.
+// File access is confined to root.
+func NewBuiltinRegistry(root string) (*Registry, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := NewRegistry()
+	for _, tool := range []Tool{
+		readFileTool(root),
+		listDirTool(root),
+		grepTool(root),
+		currentTimeTool(),
+	} {
+		if err := registry.Register(tool); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// resolve maps a path given by the model onto root, rejecting anything that
+// escapes it.
+func resolve(root string, path string) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+
+	if !within(root, path) {
+		return "", fmt.Errorf("path %q is outside of %s", path, root)
+	}
+
+	// Follow symlinks so a link inside root cannot point outside of it
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		resolvedRoot, err := filepath.EvalSymlinks(root)
+		if err != nil || !within(resolvedRoot, resolved) {
+			return "", fmt.Errorf("path %q is outside of %s", path, root)
+		}
+	}
+	return path, nil
+}
+
+func within(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// truncate cuts output to maxOutputBytes, on a character boundary so the
+// model gets valid UTF-8.
+func truncate(output string) string {
+	if len(output) <= maxOutputBytes {
+		return output
+	}
+	end := maxOutputBytes
+	for end > 0 && !utf8.RuneStart(output[end]) {
+		end--
+	}
+	return output[:end] + "\n[output truncated]"
+}
+
+func readFileTool(root string) Tool {
+	return Tool{
+		Name:        "read_file",
+		Description: "Read a text file from the working directory",
+		Schema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"path": {"type": "string", "description": "file path relative to the working directory"}
+			},
+			"required": ["path"]
+		}`),
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+
+			path, err := resolve(root, params.Path)
+			if err != nil {
+				return "", err
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			return truncate(string(data)), nil
+		},
+	}
+}
+
+func listDirTool(root string) Tool {
+	return Tool{
+		Name:        "list_dir",
+		Description: "List the entries of a directory in the working directory",
+		Schema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"path": {"type": "string", "description": "directory path relative to the working directory"}
+			}
+		}`),
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+
+			path, err := resolve(root, params.Path)
+			if err != nil {
+				return "", err
+			}
+
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return "", err
+			}
+
+			var b strings.Builder
+			for _, entry := range entries {
+				b.WriteString(entry.Name())
+				if entry.IsDir() {
+					b.WriteString("/")
+				}
+				b.WriteString("\n")
+			}
+			return truncate(b.String()), nil
+		},
+	}
+}
+
+func grepTool(root string) Tool {
+	return Tool{
+		Name:        "grep",
+		Description: "Search files in the working directory for a regular expression, honouring .gitignore",
+		Schema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"pattern": {"type": "string", "description": "Go regular expression"},
+				"path": {"type": "string", "description": "directory to search, relative to the working directory"}
+			},
+			"required": ["pattern"]
+		}`),
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				Pattern string `json:"pattern"`
+				Path    string `json:"path"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+
+			re, err := regexp.Compile(params.Pattern)
+			if err != nil {
+				return "", err
+			}
+
+			dir, err := resolve(root, params.Path)
+			if err != nil {
+				return "", err
+			}
+
+			files, err := rag.CollectFiles(dir)
+			if err != nil {
+				return "", err
+			}
+
+			var b strings.Builder
+			matches := 0
+			for _, file := range files {
+				if err := ctx.Err(); err != nil {
+					return "", err
+				}
+				if matches >= maxGrepMatches {
+					break
+				}
+				matches += grepFile(&b, re, dir, file, root, maxGrepMatches-matches)
+			}
+
+			if matches == 0 {
+				return "no matches", nil
+			}
+			return truncate(b.String()), nil
+		},
+	}
+}
+
+func grepFile(b *strings.Builder, re *regexp.Regexp, dir string, file string, root string, limit int) int {
+	path := filepath.Join(dir, filepath.FromSlash(file))
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	display, err := filepath.Rel(root, path)
+	if err != nil {
+		display = file
+	}
+
+	matches := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan() && matches < limit; line++ {
+		if re.MatchString(scanner.Text()) {
+			fmt.Fprintf(b, "%s:%d: %s\n", filepath.ToSlash(display), line, scanner.Text())
+			matches++
+		}
+	}
+	return matches
+}
+
+func currentTimeTool() Tool {
+	return Tool{
+		Name:        "current_time",
+		Description: "Get the current local date and time",
+		Schema:      json.RawMessage(`{"type": "object", "properties": {}}`),
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			return time.Now().Format(time.RFC3339), nil
+		},
+	}
+}
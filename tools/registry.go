@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"termpilot/ollamaclient"
+)
+
+// Handler runs a tool with the JSON arguments chosen by the model and returns
+// the text handed back to it.
+type Handler func(ctx context.Context, args json.RawMessage) (string, error)
+
+type Tool struct {
+	Name        string
+	Description string
+	Schema      json.RawMessage
+	Handler     Handler
+}
+
+type Registry struct {
+	tools map[string]Tool
+	order []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]Tool{}}
+}
+
+func (r *Registry) Register(tool Tool) error {
+	if tool.Name == "" || tool.Handler == nil {
+		return fmt.Errorf("tool needs a name and a handler")
+	}
+	if _, exists := r.tools[tool.Name]; exists {
+		return fmt.Errorf("tool %q is already registered", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	r.order = append(r.order, tool.Name)
+	return nil
+}
+
+func (r *Registry) Get(name string) (Tool, bool) {
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Names returns the registered tool names in registration order.
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.order...)
+}
+
+// Definitions returns the registered tools in the format sent to the model.
+func (r *Registry) Definitions() []ollamaclient.Tool {
+	definitions := make([]ollamaclient.Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tool := r.tools[name]
+		definitions = append(definitions, ollamaclient.Tool{
+			Type: "function",
+			Function: ollamaclient.ToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Schema,
+			},
+		})
+	}
+	return definitions
+}
+
+func (r *Registry) Call(ctx context.Context, name string, arguments string) (string, error) {
+	tool, ok := r.tools[name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", name)
+	}
+	if arguments == "" {
+		arguments = "{}"
+	}
+	if !json.Valid([]byte(arguments)) {
+		return "", fmt.Errorf("invalid arguments for %s: %s", name, arguments)
+	}
+	return tool.Handler(ctx, json.RawMessage(arguments))
+}
@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	echo := Tool{
+		Name:   "echo",
+		Schema: json.RawMessage(`{"type": "object"}`),
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			return string(args), nil
+		},
+	}
+	assert.NoError(t, registry.Register(echo))
+	assert.Error(t, registry.Register(echo))
+	assert.Error(t, registry.Register(Tool{Name: "no-handler"}))
+
+	definitions := registry.Definitions()
+	assert.Equal(t, 1, len(definitions))
+	assert.Equal(t, "function", definitions[0].Type)
+	assert.Equal(t, "echo", definitions[0].Function.Name)
+
+	output, err := registry.Call(context.Background(), "echo", `{"a":1}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, output)
+
+	// Empty arguments are treated as an empty object
+	output, err = registry.Call(context.Background(), "echo", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", output)
+
+	_, err = registry.Call(context.Background(), "echo", "not json")
+	assert.Error(t, err)
+
+	_, err = registry.Call(context.Background(), "missing", "{}")
+	assert.Error(t, err)
+}
+
+func TestBuiltinTools(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "pkg"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "pkg", "lib.go"), []byte("package pkg\n\nfunc Helper() {}\n"), 0644))
+
+	registry, err := NewBuiltinRegistry(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read_file", "list_dir", "grep", "current_time"}, registry.Names())
+
+	ctx := context.Background()
+
+	t.Run("ReadFile", func(t *testing.T) {
+		output, err := registry.Call(ctx, "read_file", `{"path": "main.go"}`)
+		assert.NoError(t, err)
+		assert.Contains(t, output, "func main()")
+
+		_, err = registry.Call(ctx, "read_file", `{"path": "../outside.txt"}`)
+		assert.Error(t, err)
+
+		_, err = registry.Call(ctx, "read_file", `{"path": "/etc/passwd"}`)
+		assert.Error(t, err)
+	})
+
+	t.Run("ListDir", func(t *testing.T) {
+		output, err := registry.Call(ctx, "list_dir", `{}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "main.go\npkg/\n", output)
+	})
+
+	t.Run("Grep", func(t *testing.T) {
+		output, err := registry.Call(ctx, "grep", `{"pattern": "^func "}`)
+		assert.NoError(t, err)
+		assert.Contains(t, output, "main.go:3: func main() {}")
+		assert.Contains(t, output, "pkg/lib.go:3: func Helper() {}")
+
+		output, err = registry.Call(ctx, "grep", `{"pattern": "nothing-here"}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "no matches", output)
+	})
+
+	t.Run("CurrentTime", func(t *testing.T) {
+		output, err := registry.Call(ctx, "current_time", `{}`)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, output)
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short"))
+
+	// A three byte character straddles the limit
+	output := truncate(strings.Repeat("a", maxOutputBytes-1) + "€ and more")
+	assert.True(t, utf8.ValidString(output))
+	assert.Equal(t, strings.Repeat("a", maxOutputBytes-1)+"\n[output truncated]", output)
+}